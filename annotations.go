@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/mattn/nostr-relay-ranking/ranking"
+)
+
+// annotation is an editorial note attached to a relay by the maintainer.
+type annotation struct {
+	Relay     string `json:"relay"`
+	Note      string `json:"note"`
+	Severity  string `json:"severity"` // info, warning or danger
+	ExpiresAt string `json:"expires_at,omitempty"`
+}
+
+// expired reports whether a is past its expires_at, which may be a date
+// (valid through that day) or an RFC 3339 timestamp.
+func (a annotation) expired(now time.Time) (bool, error) {
+	if a.ExpiresAt == "" {
+		return false, nil
+	}
+	if t, err := time.Parse(time.RFC3339, a.ExpiresAt); err == nil {
+		return !now.Before(t), nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", a.ExpiresAt, now.Location())
+	if err != nil {
+		return false, fmt.Errorf("invalid expires_at %q", a.ExpiresAt)
+	}
+	return !now.Before(t.AddDate(0, 0, 1)), nil
+}
+
+// loadAnnotations reads a JSON array of annotations and returns the ones
+// not yet expired, keyed by relay URL.
+func loadAnnotations(path string, now time.Time) (map[string][]annotation, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var list []annotation
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	notes := make(map[string][]annotation)
+	for _, a := range list {
+		expired, err := a.expired(now)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", path, a.Relay, err)
+		}
+		if expired || a.Note == "" {
+			continue
+		}
+		switch a.Severity {
+		case "info", "warning", "danger":
+		case "":
+			a.Severity = "info"
+		default:
+			return nil, fmt.Errorf("%s: %s: unknown severity %q", path, a.Relay, a.Severity)
+		}
+		url := ranking.NormalizeRelayURL(a.Relay)
+		notes[url] = append(notes[url], a)
+	}
+	return notes, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const maxAPILimit = 1000
+
+// rankQuery is a filter over the collected ranking, parsed from the query
+// string of an API request.
+type rankQuery struct {
+	Min   int
+	Limit int
+	Sort  string
+	Q     string
+}
+
+// parseRankQuery parses the query string, using defaultMin when min is not
+// given.
+func parseRankQuery(v url.Values, defaultMin int) (rankQuery, error) {
+	q := rankQuery{Min: defaultMin, Limit: 50, Sort: "count"}
+	if s := v.Get("min"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return q, fmt.Errorf("invalid min %q", s)
+		}
+		q.Min = n
+	}
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return q, fmt.Errorf("invalid limit %q", s)
+		}
+		q.Limit = min(n, maxAPILimit)
+	}
+	if s := v.Get("sort"); s != "" {
+		if !slices.Contains([]string{"count", "score", "name", "delta"}, s) {
+			return q, fmt.Errorf("invalid sort %q (available: count,score,name,delta)", s)
+		}
+		q.Sort = s
+	}
+	q.Q = strings.ToLower(strings.TrimSpace(v.Get("q")))
+	if len(q.Q) > 256 {
+		return q, fmt.Errorf("q is too long")
+	}
+	return q, nil
+}
+
+// apply returns the ranks matching q without modifying ranks.
+func (q rankQuery) apply(ranks []Rank) []Rank {
+	var result []Rank
+	for _, r := range ranks {
+		if r.Count < q.Min {
+			continue
+		}
+		if q.Q != "" && !strings.Contains(strings.ToLower(r.Name), q.Q) && !strings.Contains(strings.ToLower(r.Description), q.Q) {
+			continue
+		}
+		result = append(result, r)
+	}
+
+	switch q.Sort {
+	case "count":
+		slices.SortStableFunc(result, func(a, b Rank) int { return b.Count - a.Count })
+	case "score":
+		slices.SortStableFunc(result, func(a, b Rank) int {
+			switch {
+			case a.Score > b.Score:
+				return -1
+			case a.Score < b.Score:
+				return 1
+			}
+			return 0
+		})
+	case "name":
+		slices.SortStableFunc(result, func(a, b Rank) int { return strings.Compare(a.Name, b.Name) })
+	case "delta":
+		// Biggest gain in users since the previous day first. Relays
+		// without data for that day have a delta of 0.
+		slices.SortStableFunc(result, func(a, b Rank) int {
+			return cmp.Or(cmp.Compare(b.Change.delta(), a.Change.delta()), b.Count-a.Count, strings.Compare(a.Name, b.Name))
+		})
+	}
+
+	if len(result) > q.Limit {
+		result = result[:q.Limit]
+	}
+	return result
+}
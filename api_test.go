@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+
+	"github.com/mattn/nostr-relay-ranking/ranking"
+)
+
+func TestRankQuerySortDelta(t *testing.T) {
+	rank := func(name string, count, prev int) Rank {
+		r := Rank{Rank: ranking.Rank{Name: name, Count: count}}
+		if prev >= 0 {
+			r.Change = rankChange{Name: name, Count: count, PrevCount: prev}
+		}
+		return r
+	}
+	all := []Rank{
+		rank("wss://steady.example", 100, 100),
+		rank("wss://rising.example", 50, 30),
+		rank("wss://falling.example", 80, 90),
+		rank("wss://unknown.example", 60, -1), // no data for the previous day
+	}
+	q, err := parseRankQuery(url.Values{"sort": {"delta"}}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range q.apply(all) {
+		got = append(got, r.Name)
+	}
+	want := []string{"wss://rising.example", "wss://steady.example", "wss://unknown.example", "wss://falling.example"}
+	if !slices.Equal(got, want) {
+		t.Errorf("sort=delta gave %v, want %v", got, want)
+	}
+
+	if _, err := parseRankQuery(url.Values{"sort": {"random"}}, 0); err == nil {
+		t.Error("sort=random was accepted")
+	}
+}
+
+func TestServeRankingAPI(t *testing.T) {
+	s := &server{all: []Rank{
+		{Rank: ranking.Rank{Name: "wss://a.example", Count: 40}, Change: rankChange{Name: "wss://a.example", Count: 40, PrevCount: 35}},
+		{Rank: ranking.Rank{Name: "wss://b.example", Count: 30}, Change: rankChange{Name: "wss://b.example", Count: 30, PrevCount: 10}},
+		{Rank: ranking.Rank{Name: "wss://c.example", Count: 5}},
+	}, minCount: 20, metrics: newMetrics()}
+	for _, path := range []string{"/api/ranks", "/api/ranking"} {
+		rec := httptest.NewRecorder()
+		s.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path+"?sort=delta", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d", path, rec.Code)
+		}
+		var got []jsonRank
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 2 || got[0].URL != "wss://b.example" || got[0].Delta == nil || *got[0].Delta != 20 {
+			t.Errorf("%s?sort=delta = %+v, want b.example (+20) then a.example", path, got)
+		}
+	}
+}
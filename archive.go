@@ -0,0 +1,71 @@
+package main
+
+import (
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+var archiveTmpl = template.Must(template.New("archive").Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+<meta charset="UTF-8">
+<meta name="viewport" content="width=device-width, initial-scale=1.0">
+<title>{{.Title}}</title>
+<script src="https://cdn.tailwindcss.com"></script>
+</head>
+<body class="bg-gray-50 text-gray-800 min-h-screen">
+<div class="max-w-2xl mx-auto px-4 py-10">
+<h1 class="text-3xl font-bold mb-6">{{.Title}}</h1>
+<ul class="space-y-2">
+{{range .Days}}<li><a class="text-purple-600 hover:underline" href="{{.Format "2006-01-02"}}.html">{{.Format "2006-01-02"}}</a></li>
+{{end}}</ul>
+</div>
+</body>
+</html>
+`))
+
+// archivePage copies the page at src to dir as <day>.html and rewrites
+// dir/archive.html to link every archived day, newest first. It returns the
+// path of the copy.
+func archivePage(dir, src string, day time.Time, lang, siteTitle string) (string, error) {
+	b, err := os.ReadFile(src)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, day.Format("2006-01-02")+".html")
+	err = writeFileAtomic(path, func(w io.Writer) error {
+		_, err := w.Write(b)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	names, err := filepath.Glob(filepath.Join(dir, "????-??-??.html"))
+	if err != nil {
+		return "", err
+	}
+	var days []time.Time
+	for _, name := range names {
+		base := filepath.Base(name)
+		if d, err := time.Parse("2006-01-02", base[:len(base)-len(".html")]); err == nil {
+			days = append(days, d)
+		}
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].After(days[j]) })
+	data := struct {
+		Lang, Title string
+		Days        []time.Time
+	}{lang, siteTitle + " - " + translate(lang, "archive.title"), days}
+	err = writeFileAtomic(filepath.Join(dir, "archive.html"), func(w io.Writer) error {
+		return archiveTmpl.Execute(w, data)
+	})
+	return path, err
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+)
+
+//go:generate go run assets/fetch.go
+
+// echartsAssets holds the go-echarts scripts downloaded by go generate, so
+// -embed-assets can inline them. A build without them still works, only
+// -embed-assets then fails.
+//
+//go:embed assets
+var echartsAssets embed.FS
+
+// embeddedScripts returns echarts and the script of theme for inlining.
+func embeddedScripts(theme string) (echarts, themeJS template.JS, err error) {
+	b, err := fs.ReadFile(echartsAssets, "assets/echarts.min.js")
+	if err != nil {
+		return "", "", fmt.Errorf("echarts is not embedded in this build, run go generate first: %w", err)
+	}
+	t, err := fs.ReadFile(echartsAssets, "assets/themes/"+theme+".js")
+	if err != nil {
+		return "", "", fmt.Errorf("theme %s is not embedded in this build, run go generate first: %w", theme, err)
+	}
+	return template.JS(b), template.JS(t), nil
+}
@@ -0,0 +1,55 @@
+//go:build ignore
+
+// fetch downloads the go-echarts scripts embedded by -embed-assets into
+// this directory. Run it with go generate from the repository root.
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+const base = "https://go-echarts.github.io/go-echarts-assets/assets/"
+
+var files = []string{
+	"echarts.min.js",
+	"themes/chalk.js", "themes/essos.js", "themes/infographic.js", "themes/macarons.js",
+	"themes/purple-passion.js", "themes/roma.js", "themes/romantic.js", "themes/shine.js",
+	"themes/vintage.js", "themes/walden.js", "themes/westeros.js", "themes/wonderland.js",
+}
+
+func main() {
+	for _, name := range files {
+		if err := fetch(name); err != nil {
+			log.Fatalf("%s: %v", name, err)
+		}
+	}
+}
+
+func fetch(name string) error {
+	resp, err := http.Get(base + name)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	path := filepath.Join("assets", filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
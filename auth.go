@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// parseSecretKey accepts an nsec or a hex private key. An empty string
+// means no key.
+func parseSecretKey(s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+	if prefix, value, err := nip19.Decode(s); err == nil {
+		if prefix != "nsec" {
+			return "", fmt.Errorf("-nsec: expected an nsec, got %s", prefix)
+		}
+		return value.(string), nil
+	}
+	if !nostr.IsValid32ByteHex(s) {
+		return "", fmt.Errorf("-nsec: not an nsec or 64 character hex key")
+	}
+	return s, nil
+}
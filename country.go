@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/mattn/nostr-relay-ranking/ranking"
+)
+
+// vanityTLDs are country code TLDs mostly used for their look rather than
+// their country, so they say nothing about where a relay is.
+var vanityTLDs = []string{"ai", "cc", "co", "fm", "gg", "io", "me", "sh", "to", "tv", "ws"}
+
+// loadCountryMap reads a JSON object mapping relay hosts to ISO 3166
+// country codes, e.g. {"relay.example.com": "JP"}.
+func loadCountryMap(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]string
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	countries := make(map[string]string, len(m))
+	for host, code := range m {
+		countries[strings.ToLower(host)] = strings.ToUpper(code)
+	}
+	return countries, nil
+}
+
+// relayCountry attributes a relay to a country, trying in order the country
+// map, a single NIP-11 relay_countries entry and the country code TLD of the
+// host. It returns "" when none of them tells.
+func relayCountry(rurl string, info ranking.RelayInfo, countries map[string]string) string {
+	host := relayHostname(rurl)
+	if c, ok := countries[host]; ok {
+		return c
+	}
+	if len(info.RelayCountries) == 1 && len(info.RelayCountries[0]) == 2 {
+		return strings.ToUpper(info.RelayCountries[0])
+	}
+	if i := strings.LastIndex(host, "."); i >= 0 {
+		tld := host[i+1:]
+		if len(tld) == 2 && !slices.Contains(vanityTLDs, tld) {
+			if tld == "uk" {
+				return "GB"
+			}
+			return strings.ToUpper(tld)
+		}
+	}
+	return ""
+}
+
+// countryFlag returns the flag emoji of a two letter country code.
+func countryFlag(code string) string {
+	if len(code) != 2 {
+		return ""
+	}
+	var b strings.Builder
+	for _, c := range strings.ToUpper(code) {
+		if c < 'A' || c > 'Z' {
+			return ""
+		}
+		b.WriteRune(0x1F1E6 + c - 'A')
+	}
+	return b.String()
+}
+
+// parseCountries parses the -country flag into upper case codes.
+func parseCountries(s string) []string {
+	var codes []string
+	for _, c := range strings.Split(s, ",") {
+		if c = strings.ToUpper(strings.TrimSpace(c)); c != "" {
+			codes = append(codes, c)
+		}
+	}
+	return codes
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/mattn/nostr-relay-ranking/ranking"
+)
+
+// denyPatterns are the -deny patterns, on top of ignoreRelays. They are set
+// once at startup, before any event is filtered.
+var denyPatterns []string
+
+// skipTLDs are the -skip-tlds host suffixes, such as .onion, without the
+// leading dot.
+var skipTLDs []string
+
+// parseSkipTLDs parses -skip-tlds, a comma-separated list of TLDs or host
+// suffixes with or without the leading dot.
+func parseSkipTLDs(s string) []string {
+	var suffixes []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.Trim(strings.ToLower(strings.TrimSpace(p)), "."); p != "" {
+			suffixes = append(suffixes, p)
+		}
+	}
+	return suffixes
+}
+
+// loadDenyList parses -deny: the name of a file with one pattern per line
+// (blank lines and # comments are ignored) or a comma-separated list.
+func loadDenyList(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var fields []string
+	if b, err := os.ReadFile(s); err == nil {
+		scanner := bufio.NewScanner(bytes.NewReader(b))
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+				fields = append(fields, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	} else {
+		fields = strings.Split(s, ",")
+	}
+
+	var patterns []string
+	for _, p := range fields {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p == "" {
+			continue
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, err
+		}
+		if strings.Contains(p, "://") {
+			p = ranking.NormalizeRelayURL(p)
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+// denied reports whether the normalized relay url is left out of the
+// tally: it is ignored, its host ends in one of skipTLDs or it matches a
+// -deny pattern. Patterns with a scheme are matched against the whole URL,
+// others against the host, so *.example.com denies every subdomain of
+// example.com.
+func denied(url string) bool {
+	if slices.Contains(ignoreRelays, url) {
+		return true
+	}
+	host := relayHostname(url)
+	for _, suffix := range skipTLDs {
+		if host == suffix || strings.HasSuffix(host, "."+suffix) {
+			return true
+		}
+	}
+	for _, p := range denyPatterns {
+		target := host
+		if strings.Contains(p, "://") {
+			target = url
+		}
+		if ok, _ := path.Match(p, target); ok {
+			return true
+		}
+	}
+	return false
+}
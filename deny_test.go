@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestLoadDenyList(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "deny.txt")
+	content := "# spam\nWSS://Spam.Example:443/\n\n*.bad.example\n"
+	if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"wss://spam.example", "*.bad.example"}
+
+	got, err := loadDenyList(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("from a file: got %v, want %v", got, want)
+	}
+	got, err = loadDenyList(" WSS://Spam.Example:443/ , ,*.bad.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("from a list: got %v, want %v", got, want)
+	}
+	if _, err := loadDenyList("[bad"); err == nil {
+		t.Error("a malformed pattern was accepted")
+	}
+}
+
+func TestDenied(t *testing.T) {
+	defer func(p, s []string) { denyPatterns, skipTLDs = p, s }(denyPatterns, skipTLDs)
+	var err error
+	denyPatterns, err = loadDenyList("wss://spam.example,*.bad.example,exact.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	skipTLDs = nil
+
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"wss://spam.example", true},
+		{"ws://spam.example", false}, // URL patterns match the scheme too
+		{"wss://spam.example/path", false},
+		{"wss://exact.example", true},
+		{"ws://exact.example/path", true}, // host patterns match any URL of the host
+		{"wss://sub.exact.example", false},
+		{"wss://a.bad.example", true},
+		{"wss://a.b.bad.example", true},
+		{"wss://bad.example", false}, // the wildcard needs a subdomain
+		{"wss://notbad.example", false},
+		{"wss://good.example", false},
+	}
+	for _, tt := range tests {
+		if got := denied(tt.url); got != tt.want {
+			t.Errorf("denied(%q) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestSkipTLDs(t *testing.T) {
+	defer func(p, s []string) { denyPatterns, skipTLDs = p, s }(denyPatterns, skipTLDs)
+	denyPatterns = nil
+	skipTLDs = parseSkipTLDs(" .Onion, local,,")
+	if want := []string{"onion", "local"}; !slices.Equal(skipTLDs, want) {
+		t.Fatalf("parseSkipTLDs = %v, want %v", skipTLDs, want)
+	}
+
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"ws://abcdefghij234567.onion", true},
+		{"ws://sub.abcdefghij234567.onion:8080", true},
+		{"ws://relay.local", true},
+		{"ws://local", true},
+		{"wss://onion.example", false},
+		{"wss://relay.localhost", false},
+		{"wss://notlocal", false},
+		{"wss://relay.example", false},
+	}
+	for _, tt := range tests {
+		if got := denied(tt.url); got != tt.want {
+			t.Errorf("denied(%q) with -skip-tlds onion,local = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
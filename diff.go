@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+// rankChange compares a relay's position with the previous day. Rank is 0
+// for a relay that dropped out of the ranking, PrevRank is 0 for one that is
+// new to it.
+type rankChange struct {
+	Name      string
+	Rank      int
+	PrevRank  int
+	Count     int
+	PrevCount int
+}
+
+func (c rankChange) New() bool     { return c.PrevRank == 0 }
+func (c rankChange) Dropped() bool { return c.Rank == 0 }
+
+// Moved returns how many places the relay climbed, negative when it fell.
+func (c rankChange) Moved() int { return c.PrevRank - c.Rank }
+
+// delta returns how many users the relay gained since the previous day.
+func (c rankChange) delta() int { return c.Count - c.PrevCount }
+
+// computeRankChanges ranks the relays stored for prev the same way ranks is
+// ordered, by column, and compares the two. Relays below minCount on prev
+// are not ranked that day, and neither are relays left out of ranks today
+// although they had enough users, so that filters such as -jp-focus or
+// -country do not make them show up as dropped. It returns nil when
+// nothing is stored for prev.
+func computeRankChanges(db *store, countColumn, column string, ranks []Rank, counts map[string]int, minCount int, prev string) ([]rankChange, error) {
+	rows, err := db.Query("SELECT relay_url, "+countColumn+", "+column+" FROM relay_stats WHERE date = $1 AND "+column+" IS NOT NULL", prev)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ranked := make(map[string]bool, len(ranks))
+	for _, r := range ranks {
+		ranked[r.Name] = true
+	}
+	type stored struct {
+		url   string
+		count int
+		value float64
+	}
+	var before []stored
+	for rows.Next() {
+		var s stored
+		if err := rows.Scan(&s.url, &s.count, &s.value); err != nil {
+			return nil, err
+		}
+		if s.count < minCount || (counts[s.url] >= minCount && !ranked[s.url]) {
+			continue
+		}
+		before = append(before, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(before) == 0 {
+		return nil, nil
+	}
+	sort.SliceStable(before, func(i, j int) bool { return before[i].value > before[j].value })
+
+	prevRank := make(map[string]stored, len(before))
+	position := make(map[string]int, len(before))
+	for i, s := range before {
+		prevRank[s.url] = s
+		position[s.url] = i + 1
+	}
+	changes := make([]rankChange, 0, len(ranks))
+	for i, r := range ranks {
+		changes = append(changes, rankChange{
+			Name:      r.Name,
+			Rank:      i + 1,
+			PrevRank:  position[r.Name],
+			Count:     r.Count,
+			PrevCount: prevRank[r.Name].count,
+		})
+	}
+	for _, s := range before {
+		if !ranked[s.url] {
+			changes = append(changes, rankChange{
+				Name:      s.url,
+				PrevRank:  position[s.url],
+				Count:     counts[s.url],
+				PrevCount: s.count,
+			})
+		}
+	}
+	return changes, nil
+}
+
+// renderDiff writes the rank changes as a plain text report.
+func renderDiff(w io.Writer, r *myRenderer) error {
+	if r.data.Changes == nil {
+		_, err := fmt.Fprintln(w, translate(r.data.Lang, "diff.nodata"))
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "rank\tchange\turl\tcount\tdelta")
+	for _, c := range r.data.Changes {
+		rank := fmt.Sprint(c.Rank)
+		if c.Dropped() {
+			rank = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%+d\n", rank, changeLabel(c), c.Name, c.Count, c.delta())
+	}
+	return tw.Flush()
+}
+
+// changeLabel is how a rank change is shown: ▲2, ▼1, NEW, DROPPED or -.
+func changeLabel(c rankChange) string {
+	switch {
+	case c.Dropped():
+		return "DROPPED"
+	case c.New():
+		return "NEW"
+	case c.Moved() > 0:
+		return fmt.Sprintf("▲%d", c.Moved())
+	case c.Moved() < 0:
+		return fmt.Sprintf("▼%d", -c.Moved())
+	}
+	return "-"
+}
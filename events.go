@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+
+	"github.com/mattn/nostr-relay-ranking/ranking"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// loadEventsFile reads kind 10002 events, and kind 3 ones with kind3, from
+// a NDJSON file, one event per line, applying the same r-tag filtering as
+// events fetched from relays.
+func loadEventsFile(path string, kind3 bool) ([]*nostr.Event, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var events []*nostr.Event
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var ev nostr.Event
+		if err := json.Unmarshal(line, &ev); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineno, err)
+		}
+		if ev.Kind != 10002 && !(kind3 && ev.Kind == 3) {
+			continue
+		}
+		filterRelayTags(&ev)
+		events = append(events, &ev)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// dumpEvents writes the newest event of every user in events to path in the
+// NDJSON format -events-file reads, sorted by pubkey, so a run can be
+// replayed offline.
+func dumpEvents(path string, events []*nostr.Event) error {
+	latest := slices.Collect(maps.Values(ranking.LatestEvents(events)))
+	slices.SortFunc(latest, func(a, b *nostr.Event) int {
+		return cmp.Or(cmp.Compare(a.PubKey, b.PubKey), cmp.Compare(a.Kind, b.Kind))
+	})
+	return writeFileAtomic(path, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		for _, ev := range latest {
+			if err := enc.Encode(ev); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
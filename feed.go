@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// feedDays is how many days of history the feed covers.
+const feedDays = 14
+
+// feedDay is one entry of the feed: the top relays of a day and the ones
+// that moved most since the day before.
+type feedDay struct {
+	Date   time.Time
+	Top    []rankChange
+	Movers []rankChange
+}
+
+// loadFeedDays ranks the relays stored in column for each of the last
+// feedDays days with data, newest first. Days are ranked as stored, so
+// filters such as -jp-focus that only apply to the page are not reflected.
+func loadFeedDays(db *store, column string, minCount int) ([]feedDay, error) {
+	// One more day than shown, to compare the oldest entry with.
+	since := time.Now().AddDate(0, 0, -feedDays).Format("2006-01-02")
+	rows, err := db.Query("SELECT date, relay_url, "+column+" FROM relay_stats WHERE date >= $1 AND "+column+" >= $2 ORDER BY date", since, minCount)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type stored struct {
+		url   string
+		count int
+	}
+	var dates []time.Time
+	byDate := make(map[time.Time][]stored)
+	for rows.Next() {
+		var date sqlDate
+		var s stored
+		if err := rows.Scan(&date, &s.url, &s.count); err != nil {
+			return nil, err
+		}
+		if _, ok := byDate[date.Time]; !ok {
+			dates = append(dates, date.Time)
+		}
+		byDate[date.Time] = append(byDate[date.Time], s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var days []feedDay
+	prev := make(map[string]stored)
+	prevRank := make(map[string]int)
+	for i, date := range dates {
+		day := byDate[date]
+		sort.Slice(day, func(i, j int) bool {
+			if day[i].count != day[j].count {
+				return day[i].count > day[j].count
+			}
+			return day[i].url < day[j].url
+		})
+		changes := make([]rankChange, len(day))
+		rank := make(map[string]int, len(day))
+		for j, s := range day {
+			rank[s.url] = j + 1
+			changes[j] = rankChange{Name: s.url, Rank: j + 1, PrevRank: prevRank[s.url], Count: s.count, PrevCount: prev[s.url].count}
+		}
+		if i > 0 || date.Format("2006-01-02") != since {
+			days = append(days, feedDay{Date: date, Top: changes[:min(10, len(changes))], Movers: topMovers(changes, 5)})
+		}
+		prev = make(map[string]stored, len(day))
+		for _, s := range day {
+			prev[s.url] = s
+		}
+		prevRank = rank
+	}
+	for i, j := 0, len(days)-1; i < j; i, j = i+1, j-1 {
+		days[i], days[j] = days[j], days[i]
+	}
+	return days, nil
+}
+
+// topMovers returns up to n relays that climbed or fell most, ignoring new
+// ones, which have nothing to compare with.
+func topMovers(changes []rankChange, n int) []rankChange {
+	var movers []rankChange
+	for _, c := range changes {
+		if !c.New() && c.Moved() != 0 {
+			movers = append(movers, c)
+		}
+	}
+	sort.SliceStable(movers, func(i, j int) bool {
+		return abs(movers[i].Moved()) > abs(movers[j].Moved())
+	})
+	return movers[:min(n, len(movers))]
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link,omitempty"`
+	Content atomContent `xml:"content"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// feedID returns a stable id for the feed, or for the entry of date when
+// it is not zero. Entries keep their id across runs so readers do not show
+// a day twice.
+func feedID(siteURL string, date time.Time) string {
+	id := "tag:github.com,2025:mattn/nostr-relay-ranking"
+	if siteURL != "" {
+		id = siteURL
+	}
+	if date.IsZero() {
+		return id
+	}
+	sep := ":"
+	if siteURL != "" {
+		sep = "#"
+	}
+	return id + sep + date.Format("2006-01-02")
+}
+
+// renderFeed writes an Atom feed with one entry per day of r.feed.
+func renderFeed(w io.Writer, r *myRenderer) error {
+	lang := r.data.Lang
+	feed := atomFeed{
+		Title:   r.data.SiteTitle,
+		ID:      feedID(r.data.SiteURL, time.Time{}),
+		Updated: time.Now().UTC().Format(time.RFC3339),
+		Author:  atomAuthor{Name: r.data.SiteTitle},
+	}
+	if r.data.SiteURL != "" {
+		feed.Links = []atomLink{{Href: r.data.SiteURL}}
+	}
+	for _, day := range r.feed {
+		entry := atomEntry{
+			Title:   translate(lang, "feed.entry", day.Date.Format("2006-01-02")),
+			ID:      feedID(r.data.SiteURL, day.Date),
+			Updated: day.Date.UTC().Format(time.RFC3339),
+			Content: atomContent{Type: "html", Body: feedEntryHTML(lang, day)},
+		}
+		if r.data.SiteURL != "" {
+			entry.Links = []atomLink{{Href: r.data.SiteURL}}
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// feedEntryHTML renders the movers and the top 10 of day as small tables.
+func feedEntryHTML(lang string, day feedDay) string {
+	var b strings.Builder
+	table := func(heading string, changes []rankChange) {
+		fmt.Fprintf(&b, "<h3>%s</h3><table><tr><th>%s</th><th>%s</th><th>%s</th><th>%s</th></tr>",
+			html.EscapeString(heading), translate(lang, "th.rank"), translate(lang, "th.change"), translate(lang, "th.url"), translate(lang, "th.users"))
+		for _, c := range changes {
+			fmt.Fprintf(&b, "<tr><td>%d</td><td>%s</td><td>%s</td><td>%s</td></tr>",
+				c.Rank, changeLabel(c), html.EscapeString(c.Name), formatCount(c.Count))
+		}
+		b.WriteString("</table>")
+	}
+	if len(day.Movers) > 0 {
+		table(translate(lang, "feed.movers"), day.Movers)
+	}
+	table(translate(lang, "feed.top"), day.Top)
+	return b.String()
+}
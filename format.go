@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+type outputFormat struct {
+	filename string
+	render   func(w io.Writer, r *myRenderer) error
+}
+
+// outputFormats maps a -formats name to its renderer. The html output keeps
+// using OUTPUT_PATH, the others are written next to it with their filename.
+// Formats without a filename are printed to stdout.
+var outputFormats = map[string]outputFormat{
+	"html":  {filename: "index.html", render: renderHTML},
+	"json":  {filename: "ranking.json", render: renderJSON},
+	"csv":   {filename: "ranking.csv", render: renderCSV},
+	"md":    {filename: "ranking.md", render: renderMarkdown},
+	"diff":  {filename: "ranking-diff.txt", render: renderDiff},
+	"rss":   {filename: "feed.xml", render: renderFeed},
+	"table": {render: renderTable},
+}
+
+func formatNames() []string {
+	names := make([]string, 0, len(outputFormats))
+	for name := range outputFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func parseFormats(s string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if _, ok := outputFormats[name]; !ok {
+			return nil, fmt.Errorf("unknown output format %q (available: %s)", name, strings.Join(formatNames(), ","))
+		}
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no output format specified")
+	}
+	return names, nil
+}
+
+func outputFile(name, outputPath string) string {
+	if name == "html" {
+		return outputPath
+	}
+	return filepath.Join(filepath.Dir(outputPath), outputFormats[name].filename)
+}
+
+func renderHTML(w io.Writer, r *myRenderer) error {
+	if !r.compact {
+		return r.Render(w)
+	}
+	var buf bytes.Buffer
+	if err := r.Render(&buf); err != nil {
+		return err
+	}
+	_, err := w.Write(minifyHTML(buf.Bytes()))
+	return err
+}
+
+type jsonRank struct {
+	Rank        int     `json:"rank"`
+	URL         string  `json:"url"`
+	Count       int     `json:"count"`
+	Score       float64 `json:"score,omitempty"`
+	Description string  `json:"description,omitempty"`
+	Delta       *int    `json:"delta,omitempty"` // users gained since the previous day, API only
+}
+
+// jsonRanks converts the ranks of the page for the json format.
+func jsonRanks(data pageData) []jsonRank {
+	ranks := make([]jsonRank, 0, len(data.Ranks))
+	for i, rank := range data.Ranks {
+		jr := jsonRank{
+			Rank:        i + 1,
+			URL:         rank.Name,
+			Count:       rank.Count,
+			Description: rank.Description,
+		}
+		if data.Weighted {
+			jr.Score = rank.Score
+		}
+		ranks = append(ranks, jr)
+	}
+	return ranks
+}
+
+func renderJSON(w io.Writer, r *myRenderer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		UpdateTime string     `json:"update_time"`
+		Ranks      []jsonRank `json:"ranks"`
+	}{
+		UpdateTime: r.data.UpdateTime,
+		Ranks:      jsonRanks(r.data),
+	})
+}
+
+func renderCSV(w io.Writer, r *myRenderer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"rank", "url", "count", "description"}); err != nil {
+		return err
+	}
+	for i, rank := range r.data.Ranks {
+		record := []string{strconv.Itoa(i + 1), rank.Name, strconv.Itoa(rank.Count), rank.Description}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func renderTable(w io.Writer, r *myRenderer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "rank\turl\tcount")
+	for i, rank := range r.data.Ranks {
+		fmt.Fprintf(tw, "%d\t%s\t%d\n", i+1, rank.Name, rank.Count)
+	}
+	return tw.Flush()
+}
+
+func renderMarkdown(w io.Writer, r *myRenderer) error {
+	escape := strings.NewReplacer("|", `\|`, "\r\n", " ", "\n", " ", "\r", " ")
+	lang := r.data.Lang
+	if _, err := fmt.Fprintf(w, "# %s\n\n%s\n\n", r.data.SiteTitle, translate(lang, "updated", r.data.UpdateTime)); err != nil {
+		return err
+	}
+	header := fmt.Sprintf("| %s | %s | %s | %s |", translate(lang, "th.rank"), translate(lang, "th.url"), translate(lang, "th.users"), translate(lang, "th.description"))
+	if _, err := fmt.Fprintln(w, header+"\n|---:|---|---:|---|"); err != nil {
+		return err
+	}
+	for i, rank := range r.data.Ranks {
+		if _, err := fmt.Fprintf(w, "| %d | %s | %d | %s |\n", i+1, rank.Name, rank.Count, escape.Replace(rank.Description)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/mattn/nostr-relay-ranking/ranking"
+)
+
+func TestRenderLocalized(t *testing.T) {
+	data := pageData{
+		SiteTitle:  "Nostr Relay Ranking",
+		UpdateTime: "2026-10-17 09:00",
+		Ranks:      []Rank{{Rank: ranking.Rank{Name: "wss://a.example", Count: 42, Description: "a | b"}}},
+	}
+	tests := []struct {
+		lang   string
+		render func(w io.Writer, r *myRenderer) error
+		want   []string
+	}{
+		{"ja", renderMarkdown, []string{"更新日時: 2026-10-17 09:00", "| 順位 | リレーURL | 利用者数 | 説明 |", `| 1 | wss://a.example | 42 | a \| b |`}},
+		{"en", renderMarkdown, []string{"Updated: 2026-10-17 09:00", "| Rank | Relay URL | Users | Description |"}},
+		{"ja", renderDiff, []string{"前日のデータがありません"}},
+		{"en", renderDiff, []string{"No data for the previous day"}},
+	}
+	for _, tt := range tests {
+		data.Lang = tt.lang
+		var b strings.Builder
+		if err := tt.render(&b, &myRenderer{data: data}); err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(b.String(), want) {
+				t.Errorf("%s output %q does not contain %q", tt.lang, b.String(), want)
+			}
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"log"
+	"sort"
+)
+
+// growthRank is a relay on the growth leaderboard.
+type growthRank struct {
+	Name   string
+	Base   int
+	Count  int
+	Growth float64 // percent
+}
+
+// computeGrowth compares today's counts with the ones stored in column on
+// since. Relays below minBase on that day, or without data for it, are left
+// out so that tiny relays do not dominate by percentage.
+func computeGrowth(db *store, column string, result map[string]int, since string, minBase int) ([]growthRank, error) {
+	rows, err := db.Query("SELECT relay_url, "+column+" FROM relay_stats WHERE date = $1 AND "+column+" IS NOT NULL", since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	base := make(map[string]int)
+	for rows.Next() {
+		var url string
+		var cnt int
+		if err := rows.Scan(&url, &cnt); err != nil {
+			return nil, err
+		}
+		base[url] = cnt
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var growth []growthRank
+	missing := 0
+	for url, cnt := range result {
+		b, ok := base[url]
+		if !ok {
+			missing++
+			continue
+		}
+		if b < minBase || b == 0 {
+			continue
+		}
+		growth = append(growth, growthRank{
+			Name:   url,
+			Base:   b,
+			Count:  cnt,
+			Growth: float64(cnt-b) / float64(b) * 100,
+		})
+	}
+	log.Printf("✨ %s 時点のデータがないリレー %d 件は成長率ランキングから除外しました", since, missing)
+
+	sort.Slice(growth, func(i, j int) bool {
+		if growth[i].Growth != growth[j].Growth {
+			return growth[i].Growth > growth[j].Growth
+		}
+		return growth[i].Count > growth[j].Count
+	})
+	return growth, nil
+}
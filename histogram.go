@@ -0,0 +1,86 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	"github.com/go-echarts/go-echarts/v2/charts"
+	"github.com/go-echarts/go-echarts/v2/opts"
+	"github.com/mattn/nostr-relay-ranking/ranking"
+)
+
+// histogramChart draws how many relays users list, one bar per bucket.
+func histogramChart(histogram []int, lang, theme string) *charts.Bar {
+	bar := charts.NewBar()
+	bar.SetGlobalOptions(
+		charts.WithTitleOpts(opts.Title{
+			Title: translate(lang, "histogram.title"),
+			TitleStyle: &opts.TextStyle{
+				Color:      "#4f46e5",
+				FontSize:   20,
+				FontWeight: "bold",
+			},
+			Left: "center",
+		}),
+		charts.WithInitializationOpts(opts.Initialization{
+			Theme:  theme,
+			Width:  "100%",
+			Height: "400px",
+		}),
+		charts.WithTooltipOpts(opts.Tooltip{Show: opts.Bool(true), Trigger: "axis"}),
+		charts.WithXAxisOpts(opts.XAxis{Name: translate(lang, "histogram.relays")}),
+		charts.WithYAxisOpts(opts.YAxis{Name: translate(lang, "histogram.users")}),
+	)
+
+	labels := make([]string, len(ranking.UserRelayBuckets))
+	data := make([]opts.BarData, len(ranking.UserRelayBuckets))
+	for i, b := range ranking.UserRelayBuckets {
+		labels[i] = b.Label
+		data[i] = opts.BarData{Value: histogram[i]}
+	}
+	bar.SetXAxis(labels).AddSeries(translate(lang, "histogram.users"), data)
+	return bar
+}
+
+// listSizeChart draws the median and mean number of relays users listed on
+// each of days days from base. Days without data are gaps, bridged when
+// connectNulls is set.
+func listSizeChart(sizes map[string]ranking.ListSizeStats, base time.Time, days int, connectNulls bool, lang, theme string) *charts.Line {
+	line := charts.NewLine()
+	line.SetGlobalOptions(
+		charts.WithTitleOpts(opts.Title{
+			Title: translate(lang, "listsize.title"),
+			TitleStyle: &opts.TextStyle{
+				Color:      "#4f46e5",
+				FontSize:   20,
+				FontWeight: "bold",
+			},
+			Left: "center",
+		}),
+		charts.WithInitializationOpts(opts.Initialization{
+			Theme:  theme,
+			Width:  "100%",
+			Height: "400px",
+		}),
+		charts.WithTooltipOpts(opts.Tooltip{Show: opts.Bool(true), Trigger: "axis"}),
+		charts.WithLegendOpts(opts.Legend{Show: opts.Bool(true), Top: "40px"}),
+		charts.WithYAxisOpts(opts.YAxis{Name: translate(lang, "histogram.relays")}),
+	)
+
+	labels := make([]string, days)
+	median := make([]opts.LineData, days)
+	mean := make([]opts.LineData, days)
+	for i := range days {
+		day := base.AddDate(0, 0, i)
+		labels[i] = day.Format("01/02")
+		if sz, ok := sizes[day.Format("2006-01-02")]; ok {
+			median[i] = opts.LineData{Value: sz.Median}
+			mean[i] = opts.LineData{Value: math.Round(sz.Mean*100) / 100}
+		}
+	}
+	gaps := charts.WithLineChartOpts(opts.LineChart{ConnectNulls: opts.Bool(connectNulls)})
+	line.SetXAxis(labels).
+		AddSeries(translate(lang, "listsize.median"), median, gaps).
+		AddSeries(translate(lang, "listsize.mean"), mean, gaps)
+	return line
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"math/rand/v2"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// relayHostname returns the lowercased host of rurl without the port, or ""
+// when rurl does not parse.
+func relayHostname(rurl string) string {
+	u, err := url.Parse(rurl)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// hostLimiter spaces out requests to relays so relays sharing
+// infrastructure do not see a burst from us: at most n requests are in
+// flight, a host is asked one thing at a time, and each request waits a
+// random pause of up to jitter first. It paces both the NIP-11 fetches and
+// the connection probes of the ranked relays.
+type hostLimiter struct {
+	sem    chan struct{}
+	jitter time.Duration
+	mu     sync.Mutex
+	hosts  map[string]*sync.Mutex
+}
+
+func newHostLimiter(n int, jitter time.Duration) *hostLimiter {
+	return &hostLimiter{sem: make(chan struct{}, n), jitter: jitter, hosts: make(map[string]*sync.Mutex)}
+}
+
+// do runs fetch for relayURL once the limits allow it.
+func (l *hostLimiter) do(relayURL string, fetch func()) {
+	host := relayHostname(relayURL)
+	l.mu.Lock()
+	hostMu, ok := l.hosts[host]
+	if !ok {
+		hostMu = new(sync.Mutex)
+		l.hosts[host] = hostMu
+	}
+	l.mu.Unlock()
+
+	// Wait for the host before taking a slot, so relays of a busy host do
+	// not hold slots other hosts could use.
+	hostMu.Lock()
+	defer hostMu.Unlock()
+	l.sem <- struct{}{}
+	defer func() { <-l.sem }()
+	if l.jitter > 0 {
+		time.Sleep(rand.N(l.jitter))
+	}
+	fetch()
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRelayHostname(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"wss://Relay.Example", "relay.example"},
+		{"wss://relay.example:7777/path", "relay.example"},
+		{"wss://[2001:db8::1]:443", "2001:db8::1"},
+		{"%zz", ""},
+	}
+	for _, tt := range tests {
+		if got := relayHostname(tt.in); got != tt.want {
+			t.Errorf("relayHostname(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestHostLimiter(t *testing.T) {
+	l := newHostLimiter(2, 0)
+	var mu sync.Mutex
+	inFlight, peak := 0, 0
+	perHost := make(map[string]int)
+	var wg sync.WaitGroup
+	for i := range 12 {
+		// Three relays on each of four hosts.
+		url := fmt.Sprintf("wss://h%d.example/r%d", i%4, i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.do(url, func() {
+				host := relayHostname(url)
+				mu.Lock()
+				inFlight++
+				peak = max(peak, inFlight)
+				perHost[host]++
+				if perHost[host] > 1 {
+					t.Errorf("%s was asked twice at once", host)
+				}
+				mu.Unlock()
+				time.Sleep(10 * time.Millisecond)
+				mu.Lock()
+				inFlight--
+				perHost[host]--
+				mu.Unlock()
+			})
+		}()
+	}
+	wg.Wait()
+	if peak > 2 {
+		t.Errorf("%d requests in flight, want at most 2", peak)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+)
+
+// contentHash returns a strong ETag value for b.
+func contentHash(b []byte) string {
+	sum := sha256.Sum256(b)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// serveCached writes body with caching headers keyed to the time the data
+// was collected. Browsers and CDNs may reuse it for a while but have to
+// revalidate, and conditional requests are answered with 304 by
+// http.ServeContent using the ETag and Last-Modified set here.
+func serveCached(w http.ResponseWriter, r *http.Request, contentType string, collected time.Time, body []byte) {
+	h := w.Header()
+	h.Set("Content-Type", contentType)
+	h.Set("Cache-Control", "public, max-age=300, must-revalidate")
+	h.Set("ETag", contentHash(body))
+	http.ServeContent(w, r, "", collected, bytes.NewReader(body))
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+)
+
+// messages holds the human readable strings of the page and its charts per
+// language. Values may be fmt formats. Adding a locale means adding a map
+// here with the same keys; missing keys fall back to Japanese.
+var messages = map[string]map[string]string{
+	"ja": {
+		"subtitle.1":       "Nostr の kind 10002（Relay List Metadata）から集計した",
+		"subtitle.2":       "現在最も使われているリレーのランキングです（主に日本人ユーザを対象）",
+		"updated":          "更新日時: %s",
+		"sample":           " ・ 集計対象 %s ユーザー",
+		"time.layout":      "2006年01月02日 15:04",
+		"ranking.heading":  "現在の詳細ランキング（利用者数 %d人以上）",
+		"th.rank":          "順位",
+		"th.change":        "前日比",
+		"th.url":           "リレーURL",
+		"th.description":   "説明",
+		"th.software":      "ソフトウェア",
+		"th.users":         "利用者数",
+		"th.score":         "加重スコア",
+		"th.share":         "シェア",
+		"th.latency":       "応答時間",
+		"sort.hint":        "クリックで並べ替え",
+		"rank":             "%d位",
+		"change.prev":      "前日 %s人",
+		"online":           "接続可能",
+		"offline":          "接続できませんでした",
+		"nonjp":            "海外向け",
+		"mode.read":        "（読込）",
+		"mode.write":       "（書込）",
+		"profile.read":     "主に読込",
+		"profile.write":    "主に書込",
+		"profile.both":     "読み書き",
+		"growth.heading":   "急成長中のリレー（過去%d日間）",
+		"growth.base":      "%d日前",
+		"growth.now":       "現在",
+		"growth.rate":      "成長率",
+		"bridged.heading":  "ブリッジ経由の利用者数（ActivityPub など）",
+		"footer.data":      "データは日本のリレーを中心に複数の公開リレーから kind 10002 を収集・重複除去して集計しています（最大%s件/リレー）",
+		"footer.updated":   "毎日自動更新",
+		"collect.summary":  "収集時間 %s 秒 ・ 応答したリレー %d/%d",
+		"collect.slowest":  "時間のかかったリレー:",
+		"collect.failed":   "失敗",
+		"chart.title":      "Nostr Relay 利用者数推移（上位%d）",
+		"histogram.title":  "ユーザーあたりのリレー数の分布",
+		"histogram.relays": "リレー数",
+		"histogram.users":  "利用者数",
+		"listsize.title":   "ユーザーあたりのリレー数の推移",
+		"listsize.median":  "中央値",
+		"listsize.mean":    "平均",
+		"feed.entry":       "%s のランキング",
+		"feed.top":         "上位10リレー",
+		"feed.movers":      "順位変動の大きいリレー",
+		"archive.title":    "過去のランキング",
+		"diff.nodata":      "前日のデータがありません",
+	},
+	"en": {
+		"subtitle.1":       "A ranking of the most used relays right now,",
+		"subtitle.2":       "tallied from Nostr kind 10002 (Relay List Metadata), mainly of Japanese users",
+		"updated":          "Updated: %s",
+		"sample":           " · %s users sampled",
+		"time.layout":      "2006-01-02 15:04",
+		"ranking.heading":  "Current ranking (%d+ users)",
+		"th.rank":          "Rank",
+		"th.change":        "Change",
+		"th.url":           "Relay URL",
+		"th.description":   "Description",
+		"th.software":      "Software",
+		"th.users":         "Users",
+		"th.score":         "Weighted score",
+		"th.share":         "Share",
+		"th.latency":       "Latency",
+		"sort.hint":        "Click to sort",
+		"rank":             "#%d",
+		"change.prev":      "%s users yesterday",
+		"online":           "Reachable",
+		"offline":          "Could not connect",
+		"nonjp":            "Non-Japanese",
+		"mode.read":        " (read)",
+		"mode.write":       " (write)",
+		"profile.read":     "Mostly read",
+		"profile.write":    "Mostly write",
+		"profile.both":     "Read & write",
+		"growth.heading":   "Fastest growing relays (last %d days)",
+		"growth.base":      "%d days ago",
+		"growth.now":       "Now",
+		"growth.rate":      "Growth",
+		"bridged.heading":  "Users via bridges (ActivityPub etc.)",
+		"footer.data":      "Kind 10002 events are collected from several public relays, mostly Japanese ones, and deduplicated before counting (up to %s per relay)",
+		"footer.updated":   "Updated daily",
+		"collect.summary":  "Collected in %s s · %d/%d relays answered",
+		"collect.slowest":  "Slowest relays:",
+		"collect.failed":   "failed",
+		"chart.title":      "Nostr relay users over time (top %d)",
+		"histogram.title":  "Relays per user",
+		"histogram.relays": "Relays",
+		"histogram.users":  "Users",
+		"listsize.title":   "Relays per user over time",
+		"listsize.median":  "Median",
+		"listsize.mean":    "Mean",
+		"feed.entry":       "Ranking of %s",
+		"feed.top":         "Top 10 relays",
+		"feed.movers":      "Top movers",
+		"archive.title":    "Past rankings",
+		"diff.nodata":      "No data for the previous day",
+	},
+}
+
+// languages returns the languages -lang accepts.
+func languages() []string {
+	langs := make([]string, 0, len(messages))
+	for lang := range messages {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+func validLang(lang string) bool {
+	return slices.Contains(languages(), lang)
+}
+
+// translate returns the message key of lang formatted with args.
+func translate(lang, key string, args ...any) string {
+	msg, ok := messages[lang][key]
+	if !ok {
+		msg = messages["ja"][key]
+	}
+	if len(args) == 0 {
+		return msg
+	}
+	return fmt.Sprintf(msg, args...)
+}
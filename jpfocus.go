@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/mattn/nostr-relay-ranking/ranking"
+)
+
+// jpScore estimates how Japan oriented a relay is from its NIP-11 document
+// and host name. Positive scores point to Japan, negative ones away from it.
+// The reasons are returned so that the decision can be logged.
+func jpScore(rurl string, info ranking.RelayInfo) (int, []string) {
+	score := 0
+	var reasons []string
+
+	if len(info.RelayCountries) > 0 && !slices.Contains(info.RelayCountries, "*") {
+		if slices.ContainsFunc(info.RelayCountries, func(c string) bool { return strings.EqualFold(c, "JP") }) {
+			score += 2
+			reasons = append(reasons, "relay_countries=JP")
+		} else {
+			score--
+			reasons = append(reasons, "relay_countries="+strings.Join(info.RelayCountries, ","))
+		}
+	}
+
+	if len(info.LanguageTags) > 0 && !slices.Contains(info.LanguageTags, "*") {
+		if slices.ContainsFunc(info.LanguageTags, func(l string) bool {
+			l = strings.ToLower(l)
+			return l == "ja" || strings.HasPrefix(l, "ja-")
+		}) {
+			score += 2
+			reasons = append(reasons, "language_tags=ja")
+		} else {
+			score--
+			reasons = append(reasons, "language_tags="+strings.Join(info.LanguageTags, ","))
+		}
+	}
+
+	if strings.HasSuffix(relayHostname(rurl), ".jp") {
+		score += 2
+		reasons = append(reasons, "tld=.jp")
+	}
+	return score, reasons
+}
+
+// applyJPFocus marks ranks scoring below threshold as not Japan oriented and,
+// in filter mode, removes them. Relays without any signal score 0, so a
+// threshold of 0 keeps them and only removes relays clearly aimed elsewhere.
+func applyJPFocus(ranks []Rank, infos map[string]ranking.RelayInfo, mode string, threshold int) []Rank {
+	if mode == "off" {
+		return ranks
+	}
+	kept := ranks[:0]
+	for _, r := range ranks {
+		score, reasons := jpScore(r.Name, infos[r.Name])
+		r.NonJP = score < threshold
+		verdict := "JP oriented"
+		if r.NonJP {
+			verdict = "not JP oriented"
+		}
+		log.Printf("jp-focus %s: score=%d (%s) → %s", r.Name, score, strings.Join(reasons, ", "), verdict)
+		if mode == "filter" && r.NonJP {
+			continue
+		}
+		kept = append(kept, r)
+	}
+	return kept
+}
+
+func parseJPFocus(s string) (string, error) {
+	switch s {
+	case "off", "tag", "filter":
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown jp-focus mode %q (available: off,tag,filter)", s)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/mattn/nostr-relay-ranking/ranking"
+)
+
+func TestApplyJPFocus(t *testing.T) {
+	infos := map[string]ranking.RelayInfo{
+		"wss://yabu.me":          {},
+		"wss://relay.example.jp": {},
+		"wss://ja.example":       {LanguageTags: []string{"ja"}},
+		"wss://global.example":   {RelayCountries: []string{"*"}, LanguageTags: []string{"*"}},
+		"wss://us.example":       {RelayCountries: []string{"US"}, LanguageTags: []string{"en"}},
+		"wss://mixed.example":    {RelayCountries: []string{"US"}, LanguageTags: []string{"ja", "en"}},
+	}
+	ranks := func() []Rank {
+		var ranks []Rank
+		for _, name := range []string{"wss://yabu.me", "wss://relay.example.jp", "wss://ja.example", "wss://global.example", "wss://us.example", "wss://mixed.example"} {
+			ranks = append(ranks, Rank{Rank: ranking.Rank{Name: name}})
+		}
+		return ranks
+	}
+	names := func(ranks []Rank) []string {
+		var names []string
+		for _, r := range ranks {
+			names = append(names, r.Name)
+		}
+		return names
+	}
+
+	tests := []struct {
+		mode      string
+		threshold int
+		want      []string
+	}{
+		// Relays without signals, such as yabu.me, are not clearly foreign.
+		{"filter", 0, []string{"wss://yabu.me", "wss://relay.example.jp", "wss://ja.example", "wss://global.example", "wss://mixed.example"}},
+		{"filter", 1, []string{"wss://relay.example.jp", "wss://ja.example", "wss://mixed.example"}},
+		{"tag", 0, names(ranks())},
+		{"off", 5, names(ranks())},
+	}
+	for _, tt := range tests {
+		got := applyJPFocus(ranks(), infos, tt.mode, tt.threshold)
+		if !slices.Equal(names(got), tt.want) {
+			t.Errorf("-jp-focus %s -jp-threshold %d kept %v, want %v", tt.mode, tt.threshold, names(got), tt.want)
+		}
+	}
+
+	tagged := applyJPFocus(ranks(), infos, "tag", 0)
+	for _, r := range tagged {
+		if want := r.Name == "wss://us.example"; r.NonJP != want {
+			t.Errorf("%s tagged not JP oriented = %v, want %v", r.Name, r.NonJP, want)
+		}
+	}
+}
@@ -1,25 +1,36 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"crypto/sha256"
 	"database/sql"
-	"encoding/json"
+	"encoding/hex"
+	"errors"
+	"flag"
 	"fmt"
 	"html/template"
 	"io"
 	"log"
-	"net/http"
+	"math"
+	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/go-echarts/go-echarts/v2/charts"
 	"github.com/go-echarts/go-echarts/v2/opts"
 	"github.com/go-echarts/go-echarts/v2/types"
-	_ "github.com/lib/pq"
+	"github.com/mattn/nostr-relay-ranking/ranking"
 	"github.com/nbd-wtf/go-nostr"
 )
 
@@ -31,27 +42,106 @@ var ignoreRelays = []string{
 	"wss://relay.momostr.pink",
 }
 
-var pageTpl = template.Must(template.New("page").Funcs(template.FuncMap{
-	"add": func(a, b int) int { return a + b },
-	"lt":  func(a, b int) bool { return a < b },
-	"eq":  func(a, b int) bool { return a == b },
+// defaultRelays are queried when no other relay list is given.
+var defaultRelays = []string{
+	"wss://yabu.me",
+	"wss://relay-jp.nostr.wirednet.jp",
+	"wss://nostr.compile-error.net",
+	"wss://cagliostr.compile-error.net",
+	"wss://r.kojira.io",
+	//"wss://nrelay.c-stellar.net",
+	//"wss://relay.nostr.wirednet.jp",
+	//"wss://nostream.ocha.one",
+	//"wss://nostr-relay.nonce.academy",
+	//"wss://relay.damus.io",
+	//"wss://relay.nostr.bg",
+	//"wss://nos.lol",
+}
+
+// pageFuncs are the functions available to the page template, including
+// one loaded with -template.
+var pageFuncs = template.FuncMap{
+	"add":      func(a, b int) int { return a + b },
+	"lt":       func(a, b int) bool { return a < b },
+	"eq":       func(a, b int) bool { return a == b },
+	"fmtCount": formatCount,
+	"t":        translate,
+	"modeLabel": func(lang, mode string) string {
+		switch mode {
+		case "read", "write":
+			return translate(lang, "mode."+mode)
+		}
+		return ""
+	},
+	"profileLabel": func(lang, profile string) string {
+		switch profile {
+		case "read", "write":
+			return translate(lang, "profile."+profile)
+		}
+		return translate(lang, "profile.both")
+	},
+	"profileClass": func(profile string) string {
+		switch profile {
+		case "read":
+			return "bg-sky-100 text-sky-800 dark:bg-sky-900 dark:text-sky-200"
+		case "write":
+			return "bg-rose-100 text-rose-800 dark:bg-rose-900 dark:text-rose-200"
+		}
+		return "bg-gray-100 text-gray-700 dark:bg-gray-700 dark:text-gray-200"
+	},
+	"severityClass": func(severity string) string {
+		switch severity {
+		case "warning":
+			return "bg-yellow-100 text-yellow-800 dark:bg-yellow-900 dark:text-yellow-200"
+		case "danger":
+			return "bg-red-100 text-red-800 dark:bg-red-900 dark:text-red-200"
+		}
+		return "bg-blue-100 text-blue-800 dark:bg-blue-900 dark:text-blue-200"
+	},
+	"softwareName": func(software string) string {
+		// Usually a repository URL such as git+https://github.com/hoytech/strfry.git
+		software = strings.TrimSuffix(strings.TrimRight(software, "/"), ".git")
+		if i := strings.LastIndex(software, "/"); i >= 0 {
+			software = software[i+1:]
+		}
+		return software
+	},
+	"countryFlag": countryFlag,
+	"changeLabel": changeLabel,
+	"relayLink":   relayLink,
 	"stripWss": func(url string) string {
 		url = strings.TrimPrefix(url, "wss://")
 		url = strings.TrimPrefix(url, "ws://")
 		return url
 	},
-}).Parse(`
+}
+
+// pageTpl renders the page around the charts: "header" up to where the
+// charts go and "footer" after them. -template replaces it at startup.
+var pageTpl = template.Must(template.New("page").Funcs(pageFuncs).Parse(`
 {{define "header"}}
 <!DOCTYPE html>
-<html lang="ja">
+<html lang="{{.Lang}}">
 <head>
   <meta charset="utf-8">
-  <title>Nostr Relay Ranking</title>
+  <title>{{.SiteTitle}}</title>
+  {{if .OGImage}}
+  <meta property="og:title" content="{{.SiteTitle}}">
+  <meta property="og:type" content="website">
+  <meta property="og:image" content="{{.OGImage}}">
+  <meta name="twitter:card" content="summary_large_image">
+  <meta name="twitter:image" content="{{.OGImage}}">
+  {{end}}
   <meta name="viewport" content="width=device-width, initial-scale=1.0">
   <script src="https://cdn.tailwindcss.com"></script>
   <link href="https://fonts.googleapis.com/css2?family=Noto+Sans+JP:wght@400;500;700&display=swap" rel="stylesheet">
+  {{if .EchartsJS}}
+  <script>{{.EchartsJS}}</script>
+  <script>{{.ThemeJS}}</script>
+  {{else}}
   <script src="https://go-echarts.github.io/go-echarts-assets/assets/echarts.min.js"></script>
-  <script src="https://go-echarts.github.io/go-echarts-assets/assets/themes/macarons.js"></script>
+  <script src="https://go-echarts.github.io/go-echarts-assets/assets/themes/{{.ChartTheme}}.js"></script>
+  {{end}}
   <style>
     body { font-family: 'Noto Sans JP', sans-serif; }
     .echarts-container { max-width: 1280px; margin: 0 auto; padding: 20px 0; }
@@ -60,15 +150,18 @@ var pageTpl = template.Must(template.New("page").Funcs(template.FuncMap{
 <body class="bg-gray-50 dark:bg-gray-900 text-gray-900 dark:text-gray-100 min-h-screen">
 <div class="container mx-auto px-4 py-8 max-w-7xl">
   <header class="text-center mb-12">
+    {{if .LogoURL}}<img src="{{.LogoURL}}" alt="" class="mx-auto mb-4 h-20">{{end}}
     <h1 class="text-4xl md:text-6xl font-bold text-indigo-600 dark:text-indigo-400 mb-4">
-      Nostr Relay Ranking
+      {{.SiteTitle}}
     </h1>
     <p class="text-lg md:text-xl text-gray-600 dark:text-gray-300 max-w-4xl mx-auto">
-      Nostr の kind 10002（Relay List Metadata）から集計した<br class="hidden md:block">
-      現在最も使われているリレーのランキングです（主に日本人ユーザを対象）
+      {{if .SiteSubtitle}}{{.SiteSubtitle}}{{else}}
+      {{t .Lang "subtitle.1"}}<br class="hidden md:block">
+      {{t .Lang "subtitle.2"}}
+      {{end}}
     </p>
     <p class="mt-4 text-sm text-gray-500 dark:text-gray-400">
-      更新日時: {{.UpdateTime}}
+      {{t .Lang "updated" .UpdateTime}}{{if .SampleSize}}{{t .Lang "sample" (fmtCount .SampleSize)}}{{end}}
     </p>
   </header>
   <div class="echarts-container">
@@ -78,42 +171,143 @@ var pageTpl = template.Must(template.New("page").Funcs(template.FuncMap{
   </div>
   <section class="mt-20">
     <h2 class="text-3xl font-bold text-center mb-8 text-indigo-600 dark:text-indigo-400">
-      現在の詳細ランキング（利用者数 20人以上）
+      {{t .Lang "ranking.heading" .MinCount}}
     </h2>
     <div class="overflow-x-auto rounded-xl shadow-2xl bg-white dark:bg-gray-800">
       <table class="w-full min-w-max table-auto">
         <thead class="bg-gradient-to-r from-indigo-600 to-purple-600 text-white">
           <tr>
-            <th class="px-6 py-5 text-left text-sm font-semibold uppercase tracking-wider">順位</th>
-            <th class="px-6 py-5 text-left text-sm font-semibold uppercase tracking-wider">リレーURL</th>
-            <th class="px-6 py-5 text-left text-sm font-semibold uppercase tracking-wider">説明</th>
-            <th class="px-6 py-5 text-right text-sm font-semibold uppercase tracking-wider">利用者数</th>
+            <th class="px-6 py-5 text-left text-sm font-semibold uppercase tracking-wider">{{t .Lang "th.rank"}}</th>
+            {{if .Changes}}<th class="px-6 py-5 text-left text-sm font-semibold uppercase tracking-wider">{{t .Lang "th.change"}}</th>{{end}}
+            <th class="px-6 py-5 text-left text-sm font-semibold uppercase tracking-wider">{{t .Lang "th.url"}}</th>
+            <th class="px-6 py-5 text-left text-sm font-semibold uppercase tracking-wider">{{t .Lang "th.description"}}</th>
+            <th class="px-6 py-5 text-left text-sm font-semibold uppercase tracking-wider">{{t .Lang "th.software"}}</th>
+            <th class="px-6 py-5 text-right text-sm font-semibold uppercase tracking-wider">{{t .Lang "th.users"}}{{modeLabel .Lang .Mode}}</th>
+            <th class="px-6 py-5 text-right text-sm font-semibold uppercase tracking-wider">{{t .Lang "th.share"}}</th>
+            {{if .Weighted}}<th class="px-6 py-5 text-right text-sm font-semibold uppercase tracking-wider">{{t .Lang "th.score"}}</th>{{end}}
+            {{if .ShowLatency}}<th id="latency-sort" class="px-6 py-5 text-right text-sm font-semibold uppercase tracking-wider cursor-pointer select-none" title="{{t .Lang "sort.hint"}}">{{t .Lang "th.latency"}} ⇅</th>{{end}}
           </tr>
         </thead>
-        <tbody class="divide-y divide-gray-200 dark:divide-gray-700">
+        <tbody id="ranking-body" class="divide-y divide-gray-200 dark:divide-gray-700">
           {{range $i, $r := .Ranks}}
           <tr class="{{if lt $i 3}}bg-yellow-50 dark:bg-yellow-900/30{{else}}bg-gray-50 dark:bg-gray-800/50{{end}} hover:bg-gray-100 dark:hover:bg-gray-700 transition">
             <td class="px-6 py-5 font-bold text-lg">
-              {{add $i 1}}位
+              {{t $.Lang "rank" (add $i 1)}}
               {{if eq $i 0}}🥇{{else if eq $i 1}}🥈{{else if eq $i 2}}🥉{{end}}
             </td>
+            {{if $.Changes}}<td class="px-6 py-5 text-sm font-semibold {{if $r.Change.New}}text-indigo-600 dark:text-indigo-400{{else if gt $r.Change.Moved 0}}text-green-600 dark:text-green-400{{else if lt $r.Change.Moved 0}}text-red-600 dark:text-red-400{{else}}text-gray-400{{end}}" title="{{t $.Lang "change.prev" (fmtCount $r.Change.PrevCount)}}">{{changeLabel $r.Change}}</td>{{end}}
             <td class="px-6 py-5 font-mono text-sm break-all">
-              <a href="https://njump.compile-error.net/r/{{stripWss $r.Name}}" target="_blank" class="text-indigo-600 dark:text-indigo-400 hover:underline">
+              <span class="inline-block w-2 h-2 mr-1 rounded-full {{if $r.Online}}bg-green-500{{else}}bg-gray-400{{end}}" title="{{if $r.Online}}{{t $.Lang "online"}}{{else}}{{t $.Lang "offline"}}{{end}}"></span>
+              {{with relayLink $.NjumpBase $r.Name}}<a href="{{.}}" target="_blank" class="text-indigo-600 dark:text-indigo-400 hover:underline">
                 {{$r.Name}}
-              </a>
+              </a>{{else}}{{$r.Name}}{{end}}
+              {{with $r.Profile}}<span class="ml-2 px-2 py-0.5 rounded-full text-xs {{profileClass .}}" title="read {{$r.Markers.Read}} / write {{$r.Markers.Write}} / both {{$r.Markers.Both}}">{{profileLabel $.Lang .}}</span>{{end}}
+              {{with $r.Country}}<span class="ml-2 text-xs text-gray-500 dark:text-gray-400" title="{{.}}">{{countryFlag .}} {{.}}</span>{{end}}
+              {{if $r.NonJP}}<span class="ml-2 px-2 py-0.5 rounded-full text-xs bg-gray-200 dark:bg-gray-700 text-gray-600 dark:text-gray-300">{{t $.Lang "nonjp"}}</span>{{end}}
+            </td>
+            <td class="px-6 py-5 text-sm text-gray-600 dark:text-gray-300 max-w-xl">
+              {{range $r.Notes}}<span class="inline-block mb-1 mr-1 px-2 py-0.5 rounded-full text-xs font-semibold {{severityClass .Severity}}" title="{{.Note}}">{{.Note}}</span>{{end}}
+              {{$r.Description}}
             </td>
-            <td class="px-6 py-5 text-sm text-gray-600 dark:text-gray-300 max-w-xl">{{$r.Description}}</td>
-            <td class="px-6 py-5 text-right font-bold text-xl text-indigo-600 dark:text-indigo-400">{{$r.Count}}</td>
+            <td class="px-6 py-5 text-sm text-gray-600 dark:text-gray-300" title="{{$r.Software}}">{{softwareName $r.Software}} {{$r.Version}}</td>
+            <td class="px-6 py-5 text-right font-bold text-xl text-indigo-600 dark:text-indigo-400">{{fmtCount $r.Count}}</td>
+            <td class="px-6 py-5 text-right text-sm text-gray-600 dark:text-gray-300">{{if $.SampleSize}}{{printf "%.1f" $r.Share}}%{{else}}-{{end}}</td>
+            {{if $.Weighted}}<td class="px-6 py-5 text-right font-bold text-xl text-purple-600 dark:text-purple-400">{{printf "%.2f" $r.Score}}</td>{{end}}
+            {{if $.ShowLatency}}<td class="px-6 py-5 text-right text-sm text-gray-600 dark:text-gray-300" data-latency="{{if $r.Latency}}{{$r.Latency.Milliseconds}}{{end}}">{{if $r.Latency}}{{$r.Latency.Milliseconds}} ms{{else}}-{{end}}</td>{{end}}
+          </tr>
+          {{end}}
+        </tbody>
+      </table>
+    </div>
+    {{if .ShowLatency}}
+    <script>
+      // Toggles between the ranking order and fastest first; relays without
+      // a measured latency always go last.
+      (function () {
+        const body = document.getElementById("ranking-body");
+        const ranked = Array.from(body.rows);
+        let byLatency = false;
+        document.getElementById("latency-sort").addEventListener("click", function () {
+          byLatency = !byLatency;
+          const rows = ranked.slice();
+          if (byLatency) {
+            const ms = r => { const v = r.querySelector("[data-latency]").dataset.latency; return v === "" ? Infinity : Number(v); };
+            rows.sort((a, b) => ms(a) - ms(b));
+          }
+          rows.forEach(r => body.appendChild(r));
+        });
+      })();
+    </script>
+    {{end}}
+  </section>
+
+  {{if .Growth}}
+  <section class="mt-20">
+    <h2 class="text-2xl font-bold text-center mb-8 text-indigo-600 dark:text-indigo-400">
+      {{t .Lang "growth.heading" .GrowthWindow}}
+    </h2>
+    <div class="overflow-x-auto rounded-xl shadow-xl bg-white dark:bg-gray-800">
+      <table class="w-full min-w-max table-auto">
+        <thead class="bg-gradient-to-r from-emerald-600 to-teal-500 text-white">
+          <tr>
+            <th class="px-6 py-4 text-left text-sm font-semibold uppercase tracking-wider">{{t .Lang "th.rank"}}</th>
+            <th class="px-6 py-4 text-left text-sm font-semibold uppercase tracking-wider">{{t .Lang "th.url"}}</th>
+            <th class="px-6 py-4 text-right text-sm font-semibold uppercase tracking-wider">{{t .Lang "growth.base" .GrowthWindow}}</th>
+            <th class="px-6 py-4 text-right text-sm font-semibold uppercase tracking-wider">{{t .Lang "growth.now"}}</th>
+            <th class="px-6 py-4 text-right text-sm font-semibold uppercase tracking-wider">{{t .Lang "growth.rate"}}</th>
+          </tr>
+        </thead>
+        <tbody class="divide-y divide-gray-200 dark:divide-gray-700">
+          {{range $i, $g := .Growth}}
+          <tr class="bg-gray-50 dark:bg-gray-800/50 hover:bg-gray-100 dark:hover:bg-gray-700 transition">
+            <td class="px-6 py-4 font-bold">{{t $.Lang "rank" (add $i 1)}}</td>
+            <td class="px-6 py-4 font-mono text-sm break-all">{{$g.Name}}</td>
+            <td class="px-6 py-4 text-right">{{fmtCount $g.Base}}</td>
+            <td class="px-6 py-4 text-right">{{fmtCount $g.Count}}</td>
+            <td class="px-6 py-4 text-right font-bold text-lg text-emerald-600 dark:text-emerald-400">{{printf "%+.1f" $g.Growth}}%</td>
           </tr>
           {{end}}
         </tbody>
       </table>
     </div>
   </section>
+  {{end}}
+
+  {{if .Bridged}}
+  <section class="mt-20">
+    <h2 class="text-2xl font-bold text-center mb-8 text-indigo-600 dark:text-indigo-400">
+      {{t .Lang "bridged.heading"}}
+    </h2>
+    <div class="overflow-x-auto rounded-xl shadow-xl bg-white dark:bg-gray-800">
+      <table class="w-full min-w-max table-auto">
+        <thead class="bg-gradient-to-r from-gray-600 to-gray-500 text-white">
+          <tr>
+            <th class="px-6 py-4 text-left text-sm font-semibold uppercase tracking-wider">{{t .Lang "th.rank"}}</th>
+            <th class="px-6 py-4 text-left text-sm font-semibold uppercase tracking-wider">{{t .Lang "th.url"}}</th>
+            <th class="px-6 py-4 text-right text-sm font-semibold uppercase tracking-wider">{{t .Lang "th.users"}}</th>
+          </tr>
+        </thead>
+        <tbody class="divide-y divide-gray-200 dark:divide-gray-700">
+          {{range $i, $r := .Bridged}}
+          <tr class="bg-gray-50 dark:bg-gray-800/50 hover:bg-gray-100 dark:hover:bg-gray-700 transition">
+            <td class="px-6 py-4 font-bold">{{t $.Lang "rank" (add $i 1)}}</td>
+            <td class="px-6 py-4 font-mono text-sm break-all">{{$r.Name}}</td>
+            <td class="px-6 py-4 text-right font-bold text-lg">{{fmtCount $r.Count}}</td>
+          </tr>
+          {{end}}
+        </tbody>
+      </table>
+    </div>
+  </section>
+  {{end}}
 
   <footer class="mt-20 text-center text-sm text-gray-500 dark:text-gray-400">
-    <p>データは日本のリレーを中心に複数の公開リレーから kind 10002 を収集・重複除去して集計しています（最大1000件/リレー）</p>
-    <p class="mt-2">毎日自動更新 • Generated with ❤️ by Go + go-echarts + Tailwind CSS</p>
+    <p>{{t .Lang "footer.data" (fmtCount .MaxEvents)}}</p>
+    {{with .Collection}}
+    <p class="mt-2">{{t $.Lang "collect.summary" (printf "%.1f" .Duration.Seconds) .Succeeded .Queried}}</p>
+    {{with .Slowest}}<p class="mt-1">{{t $.Lang "collect.slowest"}} {{range $i, $s := .}}{{if $i}}, {{end}}{{$s.URL}} ({{printf "%.1f" $s.Duration.Seconds}}s{{if $s.Error}}, {{t $.Lang "collect.failed"}}{{end}}){{end}}</p>{{end}}
+    {{end}}
+    <p class="mt-2">{{t .Lang "footer.updated"}} • Generated with ❤️ by Go + go-echarts + Tailwind CSS</p>
   </footer>
 </div>
 </body>
@@ -121,52 +315,193 @@ var pageTpl = template.Must(template.New("page").Funcs(template.FuncMap{
 {{end}}
 `))
 
+// cleanDescription makes a NIP-11 description safe for the table: control
+// characters are dropped, runs of white space collapsed, and the text cut to
+// max runes with an ellipsis. A max of 0 keeps the whole text.
+func cleanDescription(s string, max int) string {
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) && !unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, s)
+	s = strings.Join(strings.Fields(s), " ")
+	if max > 0 && utf8.RuneCountInString(s) > max {
+		s = string([]rune(s)[:max-1]) + "…"
+	}
+	return s
+}
+
+// relayLink returns the page about the relay rurl under base: base followed
+// by its host, port and path, escaped so that IPv6 literals and ports stay
+// one valid path. It returns "" for URLs that cannot be linked.
+func relayLink(base, rurl string) string {
+	u, err := url.Parse(rurl)
+	if err != nil || (u.Scheme != "ws" && u.Scheme != "wss") || u.Host == "" || u.User != nil {
+		return ""
+	}
+	return base + url.PathEscape(u.Host) + u.EscapedPath()
+}
+
+// shortName returns name without wss:// and cut to max runes for the chart
+// legend. seen counts the names returned so far: a name cut to the same text
+// as an earlier one gets a counter, so that their series stay apart.
+func shortName(name string, max int, seen map[string]int) string {
+	short := strings.TrimPrefix(name, "wss://")
+	if utf8.RuneCountInString(short) > max {
+		short = string([]rune(short)[:max-3]) + "..."
+	}
+	seen[short]++
+	if n := seen[short]; n > 1 {
+		return fmt.Sprintf("%s #%d", short, n)
+	}
+	return short
+}
+
+// formatCount groups the digits of n by thousands, e.g. 1234 → "1,234".
+// Both Japanese and English use a comma as the separator.
+func formatCount(n int) string {
+	s := strconv.Itoa(n)
+	neg := strings.HasPrefix(s, "-")
+	if neg {
+		s = s[1:]
+	}
+	var b strings.Builder
+	for i, c := range s {
+		if i > 0 && (len(s)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(c)
+	}
+	if neg {
+		return "-" + b.String()
+	}
+	return b.String()
+}
+
+// Rank is a ranked relay together with what only the page shows about it.
 type Rank struct {
-	Name        string
-	Count       int
-	Description string
+	ranking.Rank
+	NonJP   bool
+	Country string
+	Notes   []annotation
+	Change  rankChange // compared with the previous day, if it has data
+	Share   float64    // percent of the sampled users, one decimal
 }
 
-type RelayInfo struct {
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	Pubkey      string `json:"pubkey"`
-	Contact     string `json:"contact"`
+// rankRelays returns the relays of counts with at least minCount users,
+// ordered by their score when scored and by user count otherwise.
+func rankRelays(counts map[string]int, scores map[string]float64, minCount int, scored bool) []Rank {
+	var ranks []Rank
+	for url, cnt := range counts {
+		if cnt >= minCount {
+			ranks = append(ranks, Rank{Rank: ranking.Rank{Name: url, Count: cnt, Score: scores[url]}})
+		}
+	}
+	if scored {
+		sort.Slice(ranks, func(i, j int) bool { return ranks[i].Score > ranks[j].Score })
+	} else {
+		sort.Slice(ranks, func(i, j int) bool { return ranks[i].Count > ranks[j].Count })
+	}
+	return ranks
+}
+
+// sharePercent returns count as a percentage of sample rounded to one
+// decimal, or 0 for an empty sample.
+func sharePercent(count, sample int) float64 {
+	if sample == 0 {
+		return 0
+	}
+	return math.Round(float64(count)*1000/float64(sample)) / 10
 }
 
 type pageData struct {
-	UpdateTime string
-	Ranks      []Rank
+	Lang         string // key of messages
+	SiteTitle    string
+	SiteSubtitle string
+	LogoURL      string
+	OGImage      string
+	UpdateTime   string
+	Ranks        []Rank
+	Weighted     bool
+	Mode         string
+	MinCount     int
+	MaxEvents    int // -max-events, events collected per relay at most
+	SampleSize   int
+	ShowLatency  bool
+	ChartTheme   string       // go-echarts theme of the charts
+	EchartsJS    template.JS  // inlined echarts with -embed-assets
+	ThemeJS      template.JS  // inlined ChartTheme with -embed-assets
+	NjumpBase    string       // relay links point at NjumpBase + host, see relayLink
+	SiteURL      string       // public URL of the page, may be empty
+	Changes      []rankChange // nil without data for the previous day
+	Bridged      []Rank
+	Growth       []growthRank
+	GrowthWindow int
+	Collection   *collectionStats // nil when read from -events-file
 }
 
 type myRenderer struct {
-	chart *charts.Line
-	data  pageData
+	chart     *charts.Line
+	histogram *charts.Bar
+	listSizes *charts.Line // nil unless -list-size-trend
+	data      pageData
+	compact   bool
+	feed      []feedDay // for the rss format, newest first
 }
 
-func (r *myRenderer) Render(w io.Writer) error {
+type chartRenderer interface {
+	Render(w io.Writer) error
+}
+
+// chartContent renders c as a standalone page and cuts out what is inside
+// <body>, without the default style.
+func chartContent(c chartRenderer) (string, error) {
 	var buf strings.Builder
-	if err := r.chart.Render(&buf); err != nil {
-		return err
+	if err := c.Render(&buf); err != nil {
+		return "", err
 	}
 	html := buf.String()
 
+	start := strings.Index(html, "<body>")
+	end := strings.LastIndex(html, "</body>")
+	if start == -1 || end == -1 {
+		return "", nil
+	}
+	content := html[start+6 : end]
+	styleStart := strings.Index(content, "<style>")
+	if styleStart != -1 {
+		styleEnd := strings.Index(content, "</style>")
+		if styleEnd != -1 {
+			content = content[:styleStart] + content[styleEnd+8:]
+		}
+	}
+	return content, nil
+}
+
+func (r *myRenderer) Render(w io.Writer) error {
+	renderers := []chartRenderer{r.chart}
+	if r.histogram != nil {
+		renderers = append(renderers, r.histogram)
+	}
+	if r.listSizes != nil {
+		renderers = append(renderers, r.listSizes)
+	}
+	contents := make([]string, 0, len(renderers))
+	for _, c := range renderers {
+		content, err := chartContent(c)
+		if err != nil {
+			return err
+		}
+		contents = append(contents, content)
+	}
+
 	if err := pageTpl.ExecuteTemplate(w, "header", r.data); err != nil {
 		return err
 	}
 
-	start := strings.Index(html, "<body>")
-	end := strings.LastIndex(html, "</body>")
-	if start != -1 && end != -1 {
-		chartContent := html[start+6 : end]
-		styleStart := strings.Index(chartContent, "<style>")
-		if styleStart != -1 {
-			styleEnd := strings.Index(chartContent, "</style>")
-			if styleEnd != -1 {
-				chartContent = chartContent[:styleStart] + chartContent[styleEnd+8:]
-			}
-		}
-		if _, err := w.Write([]byte(chartContent)); err != nil {
+	for _, content := range contents {
+		if _, err := w.Write([]byte(content)); err != nil {
 			return err
 		}
 	}
@@ -177,323 +512,1086 @@ func (r *myRenderer) Render(w io.Writer) error {
 	return nil
 }
 
-func fetchRelayInfo(relayURL string) RelayInfo {
-	httpURL := strings.Replace(relayURL, "wss://", "https://", 1)
-	httpURL = strings.Replace(httpURL, "ws://", "http://", 1)
-
-	client := &http.Client{Timeout: 5 * time.Second}
-	req, err := http.NewRequest("GET", httpURL, nil)
-	if err != nil {
-		return RelayInfo{}
+// filterRelayTags drops the r tags of ignored, insecure and local relays.
+func filterRelayTags(ev *nostr.Event) {
+	filteredTags := make(nostr.Tags, 0, len(ev.Tags))
+	for _, tag := range ev.Tags {
+		if len(tag) >= 2 && tag[0] == "r" {
+			url := ranking.NormalizeRelayURL(tag[1])
+			if denied(url) || strings.HasPrefix(url, "ws://") || strings.HasSuffix(url, ".local") {
+				continue
+			}
+		}
+		filteredTags = append(filteredTags, tag)
 	}
-	req.Header.Set("Accept", "application/nostr+json")
+	ev.Tags = filteredTags
+}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return RelayInfo{}
+// collectionStats describe how collecting from the relays went, for the
+// footer of the page.
+type collectionStats struct {
+	Duration  time.Duration
+	Queried   int
+	Succeeded int
+	Slowest   []ranking.RelayResult // up to 5, slowest first
+}
+
+func newCollectionStats(d time.Duration, results []ranking.RelayResult) *collectionStats {
+	slowest := slices.Clone(results)
+	slices.SortStableFunc(slowest, func(a, b ranking.RelayResult) int { return cmp.Compare(b.Duration, a.Duration) })
+	return &collectionStats{
+		Duration:  d,
+		Queried:   len(results),
+		Succeeded: succeeded(results),
+		Slowest:   slowest[:min(5, len(slowest))],
 	}
-	defer resp.Body.Close()
+}
 
-	var info RelayInfo
-	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
-		return RelayInfo{}
+// succeeded returns how many relays answered without error.
+func succeeded(results []ranking.RelayResult) int {
+	n := 0
+	for _, r := range results {
+		if r.Error == "" {
+			n++
+		}
 	}
-	return info
+	return n
+}
+
+// count fetches the relay lists from relays and tallies them. It is kept for
+// callers that predate the CollectEvents/TallyRelays split.
+func count(relays []string) map[string]int {
+	opts := ranking.DefaultFetchOptions
+	opts.Filter = filterRelayTags
+	events, _ := ranking.CollectEvents(context.Background(), relays, opts)
+	return ranking.TallyRelays(events)
 }
 
-func fetchEvents(ctx context.Context, rurl string, max int) ([]*nostr.Event, error) {
-	relay, err := nostr.RelayConnect(ctx, rurl)
+// Exit codes returned by run. Cron jobs and CI depend on them, so keep the
+// values stable when adding new ones.
+const (
+	exitOK          = 0 // everything went fine, possibly with some relays failing
+	exitError       = 1 // unexpected failure such as writing an output file
+	exitConfig      = 2 // invalid flags or configuration, nothing was collected
+	exitNoRelays    = 3 // every relay failed, nothing was collected
+	exitDB          = 4 // the database could not be opened or written
+	exitEmptyResult = 5 // collection succeeded but produced no relays to rank
+	exitInterrupted = 6 // stopped by SIGINT or SIGTERM before finishing
+)
+
+func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	formats := flag.String("formats", "html", "comma-separated list of output formats ("+strings.Join(formatNames(), ",")+")")
+	typoReport := flag.String("typo-report", "", "write pairs of relay URLs with similar hosts to this file")
+	typoDistance := flag.Int("typo-distance", 2, "maximum Levenshtein distance between hosts for -typo-report")
+	verbose := flag.Bool("verbose", false, "log per relay how many events were kept or superseded during dedup")
+	includeKind3 := flag.Bool("include-kind3", false, "also count the relays legacy clients list in the content of kind 3 contact lists, keeping the newer of a user's kind 3 and kind 10002")
+	sinceFlag := flag.String("since", "", "only count relay lists updated after this date (2006-01-02) or duration ago (e.g. 30d), which leaves out users inactive since")
+	untilFlag := flag.String("until", "", "only count relay lists updated before this date (2006-01-02) or duration ago (e.g. 7d)")
+	dumpEventsPath := flag.String("dump-events", "", "write the newest relay list of every user collected to this NDJSON file, which -events-file can replay")
+	eventsFile := flag.String("events-file", "", "read kind 10002 events from this NDJSON file instead of querying relays")
+	weight := flag.String("weight", "equal", "how users vote for their relays: equal (one vote per relay) or normalized (one vote per user, split across their relays)")
+	decay := flag.Float64("decay", 0, "rank by a score where each user's vote halves every this many days since their relay list was updated, 0 to count all users equally")
+	bridgeMode := flag.String("bridge-mode", "exclude", "how to count events proxied from the -exclude-proxies protocols: exclude, separate or include")
+	deny := flag.String("deny", "", "relays left out of the tally: a file with one pattern per line or a comma-separated list of URLs or host patterns such as *.example.com")
+	skipTLDSuffixes := flag.String("skip-tlds", "", "comma-separated TLDs or host suffixes of relays left out of the tally, such as onion,i2p,local")
+	excludeProxies := flag.String("exclude-proxies", "activitypub", "comma-separated NIP-48 proxy protocols whose events are handled by -bridge-mode ("+strings.Join(ranking.ProxyProtocols, ",")+")")
+	templatePath := flag.String("template", "", "HTML template file replacing the built-in page; it must define the header and footer templates")
+	embedAssets := flag.Bool("embed-assets", false, "inline the echarts scripts into the page instead of loading them from the go-echarts CDN (needs a build after go generate)")
+	chartTheme := flag.String("chart-theme", types.ThemeMacarons, "go-echarts theme of the charts ("+strings.Join(chartThemes, ",")+")")
+	siteTitle := flag.String("site-title", "Nostr Relay Ranking", "page title and heading")
+	lang := flag.String("lang", "ja", "language of the page ("+strings.Join(languages(), "|")+")")
+	siteURL := flag.String("site-url", "", "public URL of the page, used for links and ids in the rss feed")
+	siteSubtitle := flag.String("site-subtitle", "", "subtitle shown under the heading (default: description of the ranking)")
+	logoURL := flag.String("logo-url", "", "URL of a logo shown above the heading")
+	compactHTML := flag.Bool("compact-html", false, "minify the generated HTML")
+	hardEventCap := flag.Int("hard-event-cap", ranking.DefaultFetchOptions.HardEventCap, "stop reading a subscription after this many events, whatever limit was requested")
+	preview := flag.Bool("preview", false, "write outputs as previews (index.preview.html, ...) to be published later with the promote command")
+	outputDir := flag.String("output-dir", "", "also keep a dated copy of the HTML page (2006-01-02.html) in this directory, with an archive.html index linking them")
+	previewPath := flag.String("preview-path", "", "path of the HTML preview (default: index.preview.html next to OUTPUT_PATH)")
+	relaysFile := flag.String("relays", os.Getenv("RELAYS_FILE"), "read the seed relay list (JSON array or one URL per line) from this file, - for stdin (env RELAYS_FILE)")
+	relaysURL := flag.String("relays-url", "", "fetch the seed relay list (newline-delimited or JSON array) from this http(s) URL")
+	relaysCache := flag.String("relays-cache", "relays.cache", "local copy of -relays-url used when fetching fails")
+	strictNIP65 := flag.Bool("strict-nip65", false, "only count events that conform to NIP-65 instead of recovering relays from malformed ones")
+	jpFocus := flag.String("jp-focus", "off", "how to treat relays that do not look Japan oriented: off, tag or filter")
+	jpThreshold := flag.Int("jp-threshold", 0, "minimum -jp-focus score (NIP-11 relay_countries, language_tags and .jp TLD) for a relay to count as Japan oriented; the default 0 only leaves out relays with signals pointing away from Japan")
+	manifestPath := flag.String("manifest", "", "write a JSON manifest describing this run (config, relays, per-relay results, output hashes)")
+	annotationsPath := flag.String("annotations", "", "JSON file of editorial notes shown next to relays ([{relay, note, severity, expires_at}])")
+	connectNulls := flag.Bool("connect-nulls", true, "bridge days without data in the trend charts instead of showing gaps")
+	minHistoryDays := flag.Int("min-history-days", 0, "leave relays with fewer days of history out of the trend chart (they are still listed in the table)")
+	ogImage := flag.String("og-image", "", "also write a PNG share card of the top relays to this path and reference it from og:image")
+	ogImageURL := flag.String("og-image-url", "", "URL of the -og-image card used in the meta tags (default: its file name)")
+	ogFont := flag.String("og-font", "", "TrueType/OpenType font for the -og-image card, needed for Japanese text")
+	stripWWW := flag.Bool("strip-www", false, "count www.host and host as the same relay")
+	ignorePath := flag.Bool("ignore-path", false, "count relay URLs that differ only in their path, such as wss://host/v1 and wss://host, as the same relay")
+	growth := flag.Bool("growth", false, "add a leaderboard of the fastest growing relays")
+	growthWindow := flag.Int("growth-window", 7, "number of days the -growth leaderboard compares against")
+	minBaseCount := flag.Int("min-base-count", 20, "minimum users at the start of the -growth window for a relay to be ranked")
+	listSizeTrend := flag.Bool("list-size-trend", false, "add a chart of the median and mean number of relays users list over the -chart-days")
+	rwProfile := flag.Bool("rw-profile", false, "show whether relays are mostly listed for reading, writing or both")
+	mode := flag.String("mode", "all", "which r tags drive the ranking and chart: all, read (read and unmarked) or write (write and unmarked)")
+	nip11CachePath := flag.String("nip11-cache", "nip11.cache", "file caching NIP-11 relay information between runs, empty to disable")
+	nip11TTL := flag.Duration("nip11-ttl", 24*time.Hour, "how long cached NIP-11 relay information is used before fetching it again")
+	nip11Attempts := flag.Int("nip11-attempts", 3, "how many times to try fetching NIP-11 relay information on network errors")
+	nip11Concurrency := flag.Int("nip11-concurrency", 8, "maximum number of NIP-11 documents fetched at the same time, one at a time per host")
+	nip11Jitter := flag.Duration("nip11-jitter", 0, "wait a random time up to this long before each NIP-11 fetch (e.g. 500ms)")
+	publishRelays := flag.String("publish-relay", "", "comma-separated relays the top -publish-top ranks are published to as a kind 30078 event signed with -nsec (not in -serve mode)")
+	publishTop := flag.Int("publish-top", 30, "number of ranks in the published event")
+	publishAttempts := flag.Int("publish-attempts", 3, "how many times to try publishing to each -publish-relay")
+	nsec := flag.String("nsec", os.Getenv("NOSTR_NSEC"), "private key (nsec or hex) used to authenticate to relays that require NIP-42 auth (env NOSTR_NSEC)")
+	subscribe := flag.Bool("subscribe", false, "keep reading subscriptions for -eose-grace after EOSE, for relays that send stored events late")
+	eoseGrace := flag.Duration("eose-grace", ranking.DefaultFetchOptions.EOSEGrace, "how long -subscribe keeps reading after EOSE")
+	perHost := flag.Int("concurrency-per-host", ranking.DefaultFetchOptions.PerHost, "maximum number of relays of one site (e.g. *.example.com) connected at the same time, 0 for no limit")
+	discoveryRounds := flag.Int("discovery-rounds", 0, "after querying the seed relays, this many times also query the relays most listed in the collected events that were not queried yet")
+	discoveryTop := flag.Int("discovery-top", 20, "number of discovered relays queried per -discovery-rounds round")
+	concurrency := flag.Int("concurrency", ranking.DefaultFetchOptions.Concurrency, "maximum number of relays connected at the same time")
+	maxEvents := flag.Int("max-events", ranking.DefaultFetchOptions.MaxEvents, "page through at most this many events per relay, unless the relay list sets a limit for it")
+	relayTimeout := flag.Duration("relay-timeout", ranking.DefaultFetchOptions.RelayTimeout, "deadline for connecting to and querying a single relay")
+	dbDriver := flag.String("db-driver", "postgres", "database holding the history: postgres or sqlite (DATABASE_URL is then a file name)")
+	country := flag.String("country", "", "only rank relays attributed to these comma-separated country codes (e.g. JP)")
+	countryMapPath := flag.String("country-map", "", "JSON file mapping relay hosts to country codes, checked before NIP-11 and the TLD")
+	njumpBase := flag.String("njump-base", "https://njump.compile-error.net/r/", "base URL relay links point at, followed by the relay host")
+	maxDescription := flag.Int("max-description", 200, "cut relay descriptions longer than this many characters, 0 to show them whole")
+	latencySamples := flag.Int("latency-samples", 3, "connections made to each ranked relay to measure its median latency")
+	showLatency := flag.Bool("latency", false, "show the measured latency as a sortable table column")
+	minSample := flag.Int("min-sample", 100, "do not overwrite the stored day when fewer users than this were collected, 0 to always save")
+	dryRun := flag.Bool("dry-run", false, "collect and render as usual but do not write to the database")
+	retentionDays := flag.Int("retention-days", 90, "delete relay_stats rows older than this many days after saving, 0 to keep everything")
+	chartDays := flag.Int("chart-days", 20, "number of days shown in the trend chart")
+	topN := flag.Int("top-n", 30, "number of relays plotted in the trend chart")
+	minCount := flag.Int("min-count", 20, "minimum number of users for a relay to be ranked")
+	serveAddr := flag.String("serve", "", "serve the ranking over HTTP on this address (e.g. :8080) instead of writing files")
+	refresh := flag.Duration("refresh", time.Hour, "how often -serve collects the relay lists again")
+	crawlBudget := flag.Duration("crawl-budget", 0, "stop waiting for relays after this long and continue with what was collected (e.g. 10s)")
+	flag.Parse()
+
+	if !validLang(*lang) {
+		log.Printf("unknown -lang %q (available: %s)", *lang, strings.Join(languages(), ","))
+		return exitConfig
+	}
+	outputs, err := parseFormats(*formats)
 	if err != nil {
-		return nil, err
+		log.Print(err)
+		return exitConfig
+	}
+	if *weight != "equal" && *weight != "normalized" {
+		log.Printf("unknown weight %q (available: equal,normalized)", *weight)
+		return exitConfig
+	}
+	normalized := *weight == "normalized"
+	if !slices.Contains([]string{"all", "read", "write"}, *mode) {
+		log.Printf("unknown mode %q (available: all,read,write)", *mode)
+		return exitConfig
+	}
+	if normalized && *mode != "all" {
+		log.Print("-weight normalized cannot be combined with -mode read or write")
+		return exitConfig
+	}
+	if *decay < 0 {
+		log.Print("-decay must not be negative")
+		return exitConfig
+	}
+	if *decay > 0 && (normalized || *mode != "all") {
+		log.Print("-decay cannot be combined with -weight normalized or -mode read or write")
+		return exitConfig
+	}
+	// scored rankings are ordered by Score instead of the user count.
+	scored := normalized || *decay > 0
+	countries := make(map[string]string)
+	if *countryMapPath != "" {
+		countries, err = loadCountryMap(*countryMapPath)
+		if err != nil {
+			log.Print(err)
+			return exitConfig
+		}
+	}
+	jpMode, err := parseJPFocus(*jpFocus)
+	if err != nil {
+		log.Print(err)
+		return exitConfig
+	}
+	if *serveAddr != "" && *refresh <= 0 {
+		log.Print("-refresh must be positive")
+		return exitConfig
+	}
+	if !slices.Contains(dbDrivers, *dbDriver) {
+		log.Printf("unknown database driver %q (available: %s)", *dbDriver, strings.Join(dbDrivers, ","))
+		return exitConfig
+	}
+	if *retentionDays < 0 {
+		log.Print("-retention-days must not be negative")
+		return exitConfig
+	}
+	if *chartDays <= 0 {
+		log.Print("-chart-days must be positive")
+		return exitConfig
+	}
+	if *topN <= 0 {
+		log.Print("-top-n must be positive")
+		return exitConfig
+	}
+	if *minCount < 0 {
+		log.Print("-min-count must not be negative")
+		return exitConfig
+	}
+	if *nip11Attempts <= 0 {
+		log.Print("-nip11-attempts must be positive")
+		return exitConfig
+	}
+	secretKey, err := parseSecretKey(*nsec)
+	if err != nil {
+		log.Print(err)
+		return exitConfig
+	}
+	var publishTo []string
+	for _, u := range strings.Split(*publishRelays, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			publishTo = append(publishTo, ranking.NormalizeRelayURL(u))
+		}
+	}
+	if len(publishTo) > 0 && secretKey == "" {
+		log.Print("-publish-relay needs -nsec to sign the ranking")
+		return exitConfig
+	}
+	if *publishAttempts <= 0 {
+		log.Print("-publish-attempts must be positive")
+		return exitConfig
+	}
+	if *discoveryRounds < 0 || *discoveryTop <= 0 {
+		log.Print("-discovery-rounds must not be negative and -discovery-top must be positive")
+		return exitConfig
+	}
+	if *nip11Concurrency <= 0 {
+		log.Print("-nip11-concurrency must be positive")
+		return exitConfig
+	}
+	if *perHost < 0 {
+		log.Print("-concurrency-per-host must not be negative")
+		return exitConfig
+	}
+	if *concurrency <= 0 {
+		log.Print("-concurrency must be positive")
+		return exitConfig
+	}
+	if *latencySamples <= 0 {
+		log.Print("-latency-samples must be positive")
+		return exitConfig
+	}
+	if *maxEvents <= 0 {
+		log.Print("-max-events must be positive")
+		return exitConfig
+	}
+	if *relayTimeout <= 0 {
+		log.Print("-relay-timeout must be positive")
+		return exitConfig
+	}
+	if *hardEventCap <= 0 {
+		log.Print("-hard-event-cap must be positive")
+		return exitConfig
+	}
+	denyPatterns, err = loadDenyList(*deny)
+	if err != nil {
+		log.Printf("-deny: %v", err)
+		return exitConfig
+	}
+	skipTLDs = parseSkipTLDs(*skipTLDSuffixes)
+	proxies := parseProxies(*excludeProxies)
+	since, err := parseTimeBound(*sinceFlag, time.Now())
+	if err != nil {
+		log.Printf("-since: %v", err)
+		return exitConfig
+	}
+	until, err := parseTimeBound(*untilFlag, time.Now())
+	if err != nil {
+		log.Printf("-until: %v", err)
+		return exitConfig
+	}
+	theme := *chartTheme
+	if !slices.Contains(chartThemes, theme) {
+		log.Printf("unknown -chart-theme %q (available: %s), using %s", theme, strings.Join(chartThemes, ","), types.ThemeMacarons)
+		theme = types.ThemeMacarons
+	}
+	if *templatePath != "" {
+		pageTpl, err = loadPageTemplate(*templatePath)
+		if err != nil {
+			log.Printf("-template: %v", err)
+			return exitConfig
+		}
+	}
+	var echartsJS, themeJS template.JS
+	if *embedAssets {
+		echartsJS, themeJS, err = embeddedScripts(theme)
+		if err != nil {
+			log.Printf("-embed-assets: %v", err)
+			return exitConfig
+		}
+	}
+	for _, p := range proxies {
+		if !slices.Contains(ranking.ProxyProtocols, p) {
+			log.Printf("unknown proxy protocol %q (available: %s)", p, strings.Join(ranking.ProxyProtocols, ","))
+			return exitConfig
+		}
+	}
+	if !slices.Contains([]string{"exclude", "separate", "include"}, *bridgeMode) {
+		log.Printf("unknown bridge mode %q (available: exclude,separate,include)", *bridgeMode)
+		return exitConfig
 	}
-	defer relay.Close()
 
-	allEvents := make([]*nostr.Event, 0, max)
-	limit := 500
-	var until *nostr.Timestamp
+	outputPath := os.Getenv("OUTPUT_PATH")
+	if outputPath == "" {
+		outputPath = "index.html"
+	}
 
-	for {
-		filter := nostr.Filter{Kinds: []int{10002}, Limit: limit}
-		if until != nil {
-			filter.Until = until
+	if *outputDir != "" && !slices.Contains(outputs, "html") {
+		log.Print("-output-dir archives the html output, add html to -formats")
+		return exitConfig
+	}
+	archive := func(page string) {
+		if *outputDir == "" {
+			return
+		}
+		path, err := archivePage(*outputDir, page, time.Now(), *lang, *siteTitle)
+		if err != nil {
+			log.Printf("archive error: %v", err)
+			return
 		}
+		log.Printf("✨ %s にアーカイブしました", path)
+	}
 
-		events, err := relay.QuerySync(ctx, filter)
+	switch cmd := flag.Arg(0); cmd {
+	case "":
+	case "promote":
+		if err := promote(outputs, outputPath, *previewPath); err != nil {
+			log.Print(err)
+			return exitError
+		}
+		archive(outputPath)
+		return exitOK
+	case "stats":
+		db, err := openDBReadOnly(*dbDriver)
 		if err != nil {
-			return nil, err
+			log.Print(err)
+			return exitDB
+		}
+		defer db.Close()
+		if err := printStats(os.Stdout, db); err != nil {
+			log.Print(err)
+			return exitDB
 		}
+		return exitOK
+	default:
+		log.Printf("unknown command %q", cmd)
+		return exitConfig
+	}
 
-		for _, ev := range events {
-			filteredTags := make(nostr.Tags, 0, len(ev.Tags))
-			for _, tag := range ev.Tags {
-				if len(tag) >= 2 && tag[0] == "r" {
-					url := strings.TrimRight(strings.TrimSpace(tag[1]), "/")
-					if slices.Contains(ignoreRelays, url) || strings.HasPrefix(url, "ws://") || strings.HasSuffix(url, ".local") {
-						continue
+	// generate collects, stores and ranks the relay lists once. It is run
+	// once per invocation, or every -refresh in -serve mode.
+	generate := func() (*generation, int) {
+		startedAt := time.Now()
+		var err error
+
+		relays := defaultRelays
+		var limits map[string]int
+		switch {
+		case *relaysFile != "" && *relaysURL != "":
+			log.Print("-relays and -relays-url cannot be used together")
+			return nil, exitConfig
+		case *relaysFile != "":
+			relays, limits, err = loadRelaysFile(*relaysFile)
+			if err != nil {
+				log.Print(err)
+				return nil, exitConfig
+			}
+			relays = validRelays(relays)
+		case *relaysURL != "":
+			relays, limits, err = loadRelaysURL(*relaysURL, *relaysCache)
+			if err != nil {
+				log.Print(err)
+				return nil, exitConfig
+			}
+			relays = validRelays(relays)
+		}
+
+		var events []*nostr.Event
+		var relayResults []ranking.RelayResult
+		var collection *collectionStats // nil with -events-file
+		if *eventsFile != "" {
+			log.Printf("✨ %s からイベントを読み込みます...", *eventsFile)
+			events, err = loadEventsFile(*eventsFile, *includeKind3)
+			if err != nil {
+				log.Print(err)
+				return nil, exitConfig
+			}
+			log.Printf("%s → %d events", *eventsFile, len(events))
+			if since != nil || until != nil {
+				kept := events[:0]
+				for _, ev := range events {
+					if (since == nil || ev.CreatedAt >= *since) && (until == nil || ev.CreatedAt <= *until) {
+						kept = append(kept, ev)
 					}
 				}
-				filteredTags = append(filteredTags, tag)
+				log.Printf("%d events within -since/-until", len(kept))
+				events = kept
+			}
+		} else {
+			if len(relays) == 0 {
+				log.Print("no relays configured")
+				return nil, exitConfig
+			}
+			log.Println("✨ リレーからのデータ収集を開始します...")
+			fopts := ranking.DefaultFetchOptions
+			fopts.Filter = filterRelayTags
+			fopts.HardEventCap = *hardEventCap
+			fopts.CrawlBudget = *crawlBudget
+			fopts.RelayTimeout = *relayTimeout
+			fopts.MaxEvents = *maxEvents
+			fopts.Limits = limits
+			fopts.SecretKey = secretKey
+			fopts.Subscribe = *subscribe
+			fopts.EOSEGrace = *eoseGrace
+			fopts.Concurrency = *concurrency
+			fopts.PerHost = *perHost
+			fopts.Verbose = *verbose
+			fopts.Since, fopts.Until = since, until
+			if *includeKind3 {
+				fopts.Kinds = []int{10002, 3}
+			}
+			collectStart := time.Now()
+			events, relayResults = ranking.CollectEvents(ctx, relays, fopts)
+			if ctx.Err() != nil {
+				log.Print("interrupted, nothing was saved")
+				return nil, exitInterrupted
+			}
+			ok := succeeded(relayResults)
+			if ok == 0 {
+				log.Printf("all %d relays failed", len(relays))
+				return nil, exitNoRelays
+			}
+			if ok < len(relays) {
+				log.Printf("⚠️ %d/%d relays failed, continuing with partial data", len(relays)-ok, len(relays))
+			}
+
+			relays = slices.Clone(relays)
+			for round := 1; round <= *discoveryRounds; round++ {
+				found := discoverRelays(events, relays, *discoveryTop)
+				if len(found) == 0 {
+					break
+				}
+				log.Printf("✨ 発見したリレー %d 件からも収集します (%d/%d 回目)", len(found), round, *discoveryRounds)
+				more, moreResults := ranking.CollectEvents(ctx, found, fopts)
+				if ctx.Err() != nil {
+					log.Print("interrupted, nothing was saved")
+					return nil, exitInterrupted
+				}
+				log.Printf("%d/%d discovered relays answered with %d events", succeeded(moreResults), len(found), len(more))
+				events = append(events, more...)
+				relayResults = append(relayResults, moreResults...)
+				relays = append(relays, found...)
 			}
-			ev.Tags = filteredTags
+			collection = newCollectionStats(time.Since(collectStart), relayResults)
 		}
 
-		allEvents = append(allEvents, events...)
+		collected := len(events)
+		if *dumpEventsPath != "" {
+			if err := dumpEvents(*dumpEventsPath, events); err != nil {
+				log.Printf("dump events error: %v", err)
+			} else {
+				log.Printf("✨ 収集したイベントを %s に書き出しました", *dumpEventsPath)
+			}
+		}
+		if *includeKind3 {
+			n := len(events)
+			events = ranking.FoldKind3(events, filterRelayTags)
+			log.Printf("✨ リレー情報のない kind 3 イベント %d 件を除外しました", n-len(events))
+		}
+		events = applyNIP65Policy(events, *strictNIP65)
+		if *stripWWW {
+			mergeVariants(events, withoutWWW, "strip-www")
+		}
+		if *ignorePath {
+			mergeVariants(events, withoutPath, "ignore-path")
+		}
 
-		if len(allEvents) >= max {
-			allEvents = allEvents[:max]
-			break
+		var bridged map[string]int
+		if *bridgeMode != "include" {
+			var proxied []*nostr.Event
+			events, proxied = ranking.SplitBridged(events, proxies)
+			if *verbose {
+				for _, ev := range proxied {
+					log.Printf("%s: proxied from %s (event %s)", ev.PubKey, ranking.ProxyProtocol(ev), ev.ID)
+				}
+			}
+			if *bridgeMode == "separate" {
+				log.Printf("✨ ブリッジ経由のイベント %d 件を別枠で集計します", len(proxied))
+				bridged = ranking.TallyRelays(proxied)
+			} else {
+				log.Printf("✨ ブリッジ経由のイベント %d 件を除外しました", len(proxied))
+			}
+		}
+
+		result := ranking.TallyRelays(events)
+		weighted := ranking.TallyWeighted(events)
+		var decayed map[string]float64
+		scores, scoreColumn := weighted, "weighted_count"
+		if *decay > 0 {
+			halfLife := time.Duration(*decay * float64(24*time.Hour))
+			decayed = ranking.TallyDecayed(events, halfLife, time.Now())
+			scores, scoreColumn = decayed, "decayed_count"
+		}
+		histogram := ranking.RelaysPerUser(events)
+		sizes := ranking.RelayListSizes(events)
+		log.Printf("✨ ユーザーあたりのリレー数: 最小 %d / 中央値 %.1f / 最大 %d / 平均 %.2f", sizes.Min, sizes.Median, sizes.Max, sizes.Mean)
+		markers := ranking.TallyMarkers(events)
+		sampleSize := ranking.UniqueUsers(events)
+
+		// counts is what the ranking, chart and growth leaderboard use; result
+		// (all r tags) is still what is stored as subscription_count.
+		counts, countColumn := result, "subscription_count"
+		if *mode != "all" {
+			counts, countColumn = ranking.ModeCounts(markers, *mode), *mode+"_count"
 		}
 
-		if len(events) < limit {
-			break
+		if len(result) == 0 {
+			log.Print("no relays found in the collected events, keeping the existing data")
+			return nil, exitEmptyResult
 		}
 
-		var oldest nostr.Timestamp = nostr.Now()
-		for _, ev := range events {
-			if ev.CreatedAt < oldest {
-				oldest = ev.CreatedAt
+		if *typoReport != "" {
+			if err := saveTypoReport(*typoReport, result, *typoDistance); err != nil {
+				log.Printf("typo report error: %v", err)
 			}
 		}
-		until = &oldest
-	}
 
-	return allEvents, nil
-}
+		log.Println("✨ データ収集が完了しました。データベースに保存します...")
 
-func count(relays []string) map[string]int {
-	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-	defer cancel()
+		open := openDB
+		if *dryRun {
+			// Migrating or backfilling the schema would write too.
+			open = openDBReadOnly
+		}
+		db, err := open(*dbDriver)
+		if err != nil {
+			log.Print(err)
+			return nil, exitDB
+		}
+		defer db.Close()
 
-	seen := make(map[string]*nostr.Event)
-	var mu sync.Mutex
-	var wg sync.WaitGroup
+		today := time.Now().Format("2006-01-02")
+		var stats []relayStat
+		for url, cnt := range result {
+			stats = append(stats, relayStat{
+				URL:      url,
+				Count:    cnt,
+				Weighted: weighted[url],
+				Decayed:  sql.NullFloat64{Float64: decayed[url], Valid: decayed != nil},
+				Bridged:  bridged[url],
+				Read:     markers[url].Reads(),
+				Write:    markers[url].Writes(),
+			})
+		}
+		for url, cnt := range bridged {
+			if _, ok := result[url]; !ok {
+				stats = append(stats, relayStat{URL: url, Bridged: cnt})
+			}
+		}
 
-	for _, relay := range relays {
-		wg.Add(1)
-		go func(rurl string) {
-			defer wg.Done()
+		// A run that reached few users, typically because most relays were
+		// unreachable, must not replace a good day in the history.
+		undersampled := sampleSize < *minSample
+		switch {
+		case undersampled:
+			log.Printf("⚠️ only %d users collected, below -min-sample %d: keeping the stored data of %s", sampleSize, *minSample, today)
+		case *dryRun:
+			log.Printf("dry-run: would replace %d relay_stats rows and %d relays_per_user rows for %s, the chart only shows stored days", len(stats), len(ranking.UserRelayBuckets), today)
+		default:
+			log.Printf("✨ 今日の日付 (%s) のデータ %d 件を置き換えます...", today, len(stats))
+			meta := runMeta{Users: sampleSize, Sizes: sizes}
+			if *eventsFile == "" {
+				meta.Relays = len(relays)
+			}
+			if err := db.saveDay(today, stats, histogram, meta); err != nil {
+				log.Printf("saving %s failed, nothing was written: %v", today, err)
+				return nil, exitDB
+			}
+			log.Println("✨ リレー統計をデータベースに保存しました")
+		}
 
-			events, err := fetchEvents(ctx, rurl, 10000)
+		if *retentionDays > 0 && !*dryRun && !undersampled {
+			cutoff := time.Now().AddDate(0, 0, -*retentionDays).Format("2006-01-02")
+			n, err := db.prune(cutoff)
 			if err != nil {
-				log.Printf("query error %s: %v", rurl, err)
-				return
+				log.Printf("prune error: %v", err)
+			} else {
+				log.Printf("✨ %s より古いデータ %d 件を削除しました", cutoff, n)
 			}
+		}
 
-			mu.Lock()
-			for _, ev := range events {
-				if old, ok := seen[ev.PubKey]; !ok || old.CreatedAt < ev.CreatedAt {
-					seen[ev.PubKey] = ev
-				}
+		ranks := rankRelays(counts, scores, *minCount, scored)
+		for i := range ranks {
+			if *rwProfile {
+				ranks[i].Markers = markers[ranks[i].Name]
+				ranks[i].Profile = ranks[i].Markers.Profile()
 			}
-			mu.Unlock()
-			log.Printf("%s → %d events", rurl, len(events))
-		}(relay)
-	}
-	wg.Wait()
+			ranks[i].Share = sharePercent(ranks[i].Count, sampleSize)
+		}
+
+		var bridgedRanks []Rank
+		for url, cnt := range bridged {
+			bridgedRanks = append(bridgedRanks, Rank{Rank: ranking.Rank{Name: url, Count: cnt}})
+		}
+		sort.Slice(bridgedRanks, func(i, j int) bool { return bridgedRanks[i].Count > bridgedRanks[j].Count })
+		if len(bridgedRanks) > 20 {
+			bridgedRanks = bridgedRanks[:20]
+		}
 
-	result := make(map[string]int)
-	for _, ev := range seen {
-		for _, tag := range ev.Tags {
-			if len(tag) >= 2 && tag[0] == "r" {
-				url := strings.TrimRight(strings.TrimSpace(tag[1]), "/")
-				if strings.HasPrefix(url, "ws") {
-					result[url]++
+		limiter := newHostLimiter(*nip11Concurrency, *nip11Jitter)
+		// Probes connect like the crawl does, so they share its bound.
+		probes := newHostLimiter(*concurrency, 0)
+		relayInfo := func(relayURL string) ranking.RelayInfo {
+			var info ranking.RelayInfo
+			limiter.do(relayURL, func() { info = ranking.FetchRelayInfo(relayURL, *nip11Attempts) })
+			if !info.Empty() {
+				if !*dryRun {
+					if err := db.saveRelayInfo(relayURL, info, time.Now()); err != nil {
+						log.Printf("saving NIP-11 of %s failed: %v", relayURL, err)
+					}
 				}
+				return info
+			}
+			stored, fetchedAt, err := db.storedRelayInfo(relayURL)
+			if err != nil {
+				if !errors.Is(err, sql.ErrNoRows) {
+					log.Printf("loading NIP-11 of %s failed: %v", relayURL, err)
+				}
+				return info
+			}
+			log.Printf("%s: using the NIP-11 document fetched at %s", relayURL, fetchedAt.Format("2006-01-02 15:04"))
+			return stored
+		}
+		var nip11 *nip11Cache
+		if *nip11CachePath != "" {
+			nip11 = loadNIP11Cache(*nip11CachePath, *nip11TTL, relayInfo)
+			relayInfo = nip11.relayInfo
+		}
+		infos := make(map[string]ranking.RelayInfo)
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		for i := range ranks {
+			wg.Add(1)
+			go func(idx int) {
+				defer wg.Done()
+				var online bool
+				var latency time.Duration
+				probed := make(chan struct{})
+				go func() {
+					probes.do(ranks[idx].Name, func() {
+						online, latency = ranking.Probe(ranks[idx].Name, *latencySamples)
+					})
+					close(probed)
+				}()
+				info := relayInfo(ranks[idx].Name)
+				<-probed
+				mu.Lock()
+				ranks[idx].Online = online
+				ranks[idx].Latency = latency
+				if !online {
+					log.Printf("%s did not accept a connection", ranks[idx].Name)
+				}
+				ranks[idx].Description = cleanDescription(info.Description, *maxDescription)
+				ranks[idx].Software = info.Software
+				ranks[idx].Version = info.Version
+				infos[ranks[idx].Name] = info
+				mu.Unlock()
+			}(i)
+		}
+		wg.Wait()
+		if nip11 != nil {
+			if err := nip11.save(); err != nil {
+				log.Printf("write NIP-11 cache %s: %v", *nip11CachePath, err)
 			}
 		}
-	}
-	return result
-}
 
-func main() {
-	relays := []string{
-		"wss://yabu.me",
-		"wss://relay-jp.nostr.wirednet.jp",
-		"wss://nostr.compile-error.net",
-		"wss://cagliostr.compile-error.net",
-		"wss://r.kojira.io",
-		//"wss://nrelay.c-stellar.net",
-		//"wss://relay.nostr.wirednet.jp",
-		//"wss://nostream.ocha.one",
-		//"wss://nostr-relay.nonce.academy",
-		//"wss://relay.damus.io",
-		//"wss://relay.nostr.bg",
-		//"wss://nos.lol",
-	}
+		log.Println("✨ リレー情報の取得が完了しました")
 
-	log.Println("✨ リレーからのデータ収集を開始します...")
+		ranks = applyJPFocus(ranks, infos, jpMode, *jpThreshold)
 
-	result := count(relays)
+		attrs := make(map[string]relayAttrs)
+		for i := range ranks {
+			ranks[i].Country = relayCountry(ranks[i].Name, infos[ranks[i].Name], countries)
+			attrs[ranks[i].Name] = relayAttrs{Country: ranks[i].Country, Latency: ranks[i].Latency}
+		}
+		if !*dryRun && !undersampled {
+			if err := db.setRelayAttrs(today, attrs); err != nil {
+				log.Printf("saving relay attributes failed: %v", err)
+			}
+		}
+		if codes := parseCountries(*country); len(codes) > 0 {
+			kept := ranks[:0]
+			for _, r := range ranks {
+				if slices.Contains(codes, r.Country) {
+					kept = append(kept, r)
+				}
+			}
+			log.Printf("✨ 国 %s のリレー %d/%d 件に絞り込みました", strings.Join(codes, ","), len(kept), len(ranks))
+			ranks = kept
+		}
 
-	log.Println("✨ データ収集が完了しました。データベースに保存します...")
+		if *annotationsPath != "" {
+			notes, err := loadAnnotations(*annotationsPath, time.Now())
+			if err != nil {
+				log.Printf("annotations error: %v", err)
+			} else {
+				for i := range ranks {
+					ranks[i].Notes = notes[ranks[i].Name]
+				}
+			}
+		}
 
-	dbURL := os.Getenv("DATABASE_URL")
-	db, err := sql.Open("postgres", dbURL)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer db.Close()
+		prev := time.Now().AddDate(0, 0, -1).Format("2006-01-02")
+		changeColumn := countColumn
+		if scored {
+			changeColumn = scoreColumn
+		}
+		changes, err := computeRankChanges(db, countColumn, changeColumn, ranks, counts, *minCount, prev)
+		if err != nil {
+			log.Printf("rank diff error: %v", err)
+		}
+		for i := range ranks {
+			if i < len(changes) {
+				ranks[i].Change = changes[i]
+			}
+		}
 
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS relay_stats (
-			id SERIAL PRIMARY KEY,
-			date DATE NOT NULL,
-			relay_url TEXT NOT NULL,
-			subscription_count INTEGER NOT NULL,
-			UNIQUE(date, relay_url)
+		line := charts.NewLine()
+		line.SetGlobalOptions(
+			charts.WithTitleOpts(opts.Title{
+				Title: translate(*lang, "chart.title", *topN),
+				TitleStyle: &opts.TextStyle{
+					Color:      "#4f46e5",
+					FontSize:   24,
+					FontWeight: "bold",
+				},
+				Left: "center",
+			}),
+			charts.WithInitializationOpts(opts.Initialization{
+				Theme:  theme,
+				Width:  "100%",
+				Height: "700px",
+			}),
+			charts.WithTooltipOpts(opts.Tooltip{Show: opts.Bool(true), Trigger: "axis"}),
+			charts.WithLegendOpts(opts.Legend{
+				Show:   opts.Bool(true),
+				Orient: "horizontal",
+				Bottom: "5%",
+			}),
+			charts.WithGridOpts(opts.Grid{
+				Left:         "3%",
+				Right:        "4%",
+				Bottom:       "35%",
+				Top:          "10%",
+				ContainLabel: opts.Bool(true),
+			}),
 		)
-	`)
-	if err != nil {
-		log.Fatal(err)
-	}
 
-	_, err = db.Exec(`
-		CREATE INDEX IF NOT EXISTS idx_relay_stats_url_date 
-		ON relay_stats(relay_url, date)
-	`)
-	if err != nil {
-		log.Fatal(err)
-	}
+		dates := make([]string, *chartDays)
+		base := time.Now().AddDate(0, 0, -(*chartDays - 1))
+		for i := 0; i < *chartDays; i++ {
+			dates[i] = base.AddDate(0, 0, i).Format("01/02")
+		}
+		line.SetXAxis(dates)
 
-	tx, err := db.Begin()
-	if err != nil {
-		log.Fatal(err)
-	}
+		limit := *topN
+		column := countColumn
+		if scored {
+			column = scoreColumn
+		}
+		plotted := 0
+		shortened := make(map[string]int)
+		for _, r := range ranks {
+			if plotted >= limit {
+				break
+			}
+			var series []opts.LineData
+			points := 0
+			for i := 0; i < *chartDays; i++ {
+				queryDate := base.AddDate(0, 0, i).Format("2006-01-02")
+				var cnt float64
+				err := db.QueryRow("SELECT "+column+" FROM relay_stats WHERE relay_url = $1 AND date = $2", r.Name, queryDate).Scan(&cnt)
+				if err != nil {
+					series = append(series, opts.LineData{})
+				} else {
+					series = append(series, opts.LineData{Value: cnt})
+					points++
+				}
+			}
+			if points < *minHistoryDays {
+				log.Printf("%s has only %d days of history, not plotting it", r.Name, points)
+				continue
+			}
+			plotted++
+			short := shortName(r.Name, 30, shortened)
+			label := fmt.Sprintf("%s (%s)", short, formatCount(r.Count))
+			if scored {
+				label = fmt.Sprintf("%s (%.2f)", short, r.Score)
+			}
+			line.AddSeries(label, series,
+				charts.WithLineChartOpts(opts.LineChart{
+					Smooth:       opts.Bool(true),
+					ShowSymbol:   opts.Bool(false),
+					ConnectNulls: opts.Bool(*connectNulls),
+				}))
+		}
 
-	log.Printf("✨ 今日の日付 (%s) の既存データを削除します...", time.Now().Format("2006-01-02"))
+		var growthRanks []growthRank
+		if *growth {
+			since := time.Now().AddDate(0, 0, -*growthWindow).Format("2006-01-02")
+			growthRanks, err = computeGrowth(db, countColumn, counts, since, *minBaseCount)
+			if err != nil {
+				log.Printf("growth error: %v", err)
+			}
+			if len(growthRanks) > 20 {
+				growthRanks = growthRanks[:20]
+			}
+		}
 
-	today := time.Now().Format("2006-01-02")
-	tx.Exec("DELETE FROM relay_stats WHERE date = $1", today)
+		if len(ranks) > 50 {
+			ranks = ranks[:50]
+		}
 
-	log.Printf("✨ 今日の日付 (%s) の新しいデータ %d 件を挿入します...", today, len(result))
+		data := pageData{
+			Lang:         *lang,
+			SiteTitle:    *siteTitle,
+			SiteSubtitle: *siteSubtitle,
+			LogoURL:      *logoURL,
+			UpdateTime:   time.Now().Format(translate(*lang, "time.layout")),
+			Ranks:        ranks,
+			Weighted:     scored,
+			Mode:         *mode,
+			MinCount:     *minCount,
+			MaxEvents:    *maxEvents,
+			SampleSize:   sampleSize,
+			ShowLatency:  *showLatency,
+			ChartTheme:   theme,
+			EchartsJS:    echartsJS,
+			ThemeJS:      themeJS,
+			NjumpBase:    *njumpBase,
+			SiteURL:      *siteURL,
+			Changes:      changes,
+			Bridged:      bridgedRanks,
+			Growth:       growthRanks,
+			Collection:   collection,
+			GrowthWindow: *growthWindow,
+		}
 
-	stmt, err := tx.Prepare("INSERT INTO relay_stats(date, relay_url, subscription_count) VALUES($1, $2, $3)")
-	if err != nil {
-		log.Fatal(err)
-	}
+		if *ogImage != "" {
+			data.OGImage = *ogImageURL
+			if data.OGImage == "" {
+				data.OGImage = filepath.Base(*ogImage)
+			}
+		}
 
-	for url, cnt := range result {
-		if cnt >= 0 {
-			stmt.Exec(today, url, cnt)
+		renderer := &myRenderer{chart: line, histogram: histogramChart(histogram, *lang, theme), data: data, compact: *compactHTML}
+		if *listSizeTrend {
+			stored, err := db.listSizes(base.Format("2006-01-02"))
+			if err != nil {
+				log.Printf("list size trend error: %v", err)
+			} else {
+				renderer.listSizes = listSizeChart(stored, base, *chartDays, *connectNulls, *lang, theme)
+			}
+		}
+		if *serveAddr != "" || slices.Contains(outputs, "rss") {
+			renderer.feed, err = loadFeedDays(db, countColumn, *minCount)
+			if err != nil {
+				log.Printf("feed error: %v", err)
+			}
+		}
+		described := make(map[string]string, len(ranks))
+		for _, r := range ranks {
+			described[r.Name] = r.Description
 		}
+		changed := make(map[string]rankChange, len(changes))
+		for _, c := range changes {
+			changed[c.Name] = c
+		}
+		// Like the json format, the API only has scores for scored rankings.
+		all := make([]Rank, 0, len(counts))
+		for url, cnt := range counts {
+			r := Rank{Rank: ranking.Rank{Name: url, Count: cnt, Description: described[url]}, Change: changed[url]}
+			if scored {
+				r.Score = scores[url]
+			}
+			all = append(all, r)
+		}
+
+		return &generation{
+			renderer:     renderer,
+			relays:       relays,
+			relayResults: relayResults,
+			startedAt:    startedAt,
+			duration:     time.Since(startedAt),
+			events:       collected,
+			users:        sampleSize,
+			all:          all,
+		}, exitOK
 	}
-	tx.Commit()
 
-	log.Println("✨ リレー統計をデータベースに保存しました")
+	if *serveAddr != "" {
+		return serve(ctx, *serveAddr, *refresh, *minCount, generate)
+	}
 
-	var ranks []Rank
-	for url, cnt := range result {
-		if cnt >= 20 {
-			ranks = append(ranks, Rank{Name: url, Count: cnt})
-		}
-	}
-	sort.Slice(ranks, func(i, j int) bool { return ranks[i].Count > ranks[j].Count })
-
-	var wg sync.WaitGroup
-	var mu sync.Mutex
-	for i := range ranks {
-		wg.Add(1)
-		go func(idx int) {
-			defer wg.Done()
-			info := fetchRelayInfo(ranks[idx].Name)
-			mu.Lock()
-			ranks[idx].Description = info.Description
-			mu.Unlock()
-		}(i)
-	}
-	wg.Wait()
-
-	log.Println("✨ リレー情報の取得が完了しました")
-
-	line := charts.NewLine()
-	line.SetGlobalOptions(
-		charts.WithTitleOpts(opts.Title{
-			Title: "Nostr Relay 利用者数推移（上位30）",
-			TitleStyle: &opts.TextStyle{
-				Color:      "#4f46e5",
-				FontSize:   24,
-				FontWeight: "bold",
-			},
-			Left: "center",
-		}),
-		charts.WithInitializationOpts(opts.Initialization{
-			Theme:  types.ThemeMacarons,
-			Width:  "100%",
-			Height: "700px",
-		}),
-		charts.WithTooltipOpts(opts.Tooltip{Show: opts.Bool(true), Trigger: "axis"}),
-		charts.WithLegendOpts(opts.Legend{
-			Show:   opts.Bool(true),
-			Orient: "horizontal",
-			Bottom: "5%",
-		}),
-		charts.WithGridOpts(opts.Grid{
-			Left:         "3%",
-			Right:        "4%",
-			Bottom:       "35%",
-			Top:          "10%",
-			ContainLabel: opts.Bool(true),
-		}),
-	)
-
-	dates := make([]string, 20)
-	base := time.Now().AddDate(0, 0, -19)
-	for i := 0; i < 20; i++ {
-		dates[i] = base.AddDate(0, 0, i).Format("01/02")
-	}
-	line.SetXAxis(dates)
-
-	limit := 30
-	if len(ranks) < limit {
-		limit = len(ranks)
-	}
-	for _, r := range ranks[:limit] {
-		var series []opts.LineData
-		for i := 0; i < 20; i++ {
-			queryDate := base.AddDate(0, 0, i).Format("2006-01-02")
-			var cnt int
-			err := db.QueryRow("SELECT subscription_count FROM relay_stats WHERE relay_url = $1 AND date = $2", r.Name, queryDate).Scan(&cnt)
-			if err != nil {
-				series = append(series, opts.LineData{})
-			} else {
-				series = append(series, opts.LineData{Value: cnt})
+	gen, code := generate()
+	if code != exitOK {
+		return code
+	}
+	renderer, data, startedAt := gen.renderer, gen.renderer.data, gen.startedAt
+	var written []manifestOutput
+	for _, name := range outputs {
+		if outputFormats[name].filename == "" {
+			if err := outputFormats[name].render(os.Stdout, renderer); err != nil {
+				log.Print(err)
+				return exitError
 			}
+			continue
 		}
-		short := strings.TrimPrefix(r.Name, "wss://")
-		if len(short) > 30 {
-			short = short[:27] + "..."
+		path := outputFile(name, outputPath)
+		if *preview {
+			path = previewFile(path)
+			if name == "html" && *previewPath != "" {
+				path = *previewPath
+			}
+		}
+		render := outputFormats[name].render
+		h := sha256.New()
+		err := writeFileAtomic(path, func(w io.Writer) error {
+			return render(io.MultiWriter(w, h), renderer)
+		})
+		if err != nil {
+			log.Print(err)
+			return exitError
+		}
+		written = append(written, manifestOutput{Format: name, Path: path, SHA256: hex.EncodeToString(h.Sum(nil))})
+		log.Printf("✨ %s が美しく生成されました！", path)
+		// Previews are archived once promoted.
+		if name == "html" && !*preview {
+			archive(path)
 		}
-		line.AddSeries(fmt.Sprintf("%s (%d)", short, r.Count), series,
-			charts.WithLineChartOpts(opts.LineChart{
-				Smooth:       opts.Bool(true),
-				ShowSymbol:   opts.Bool(false),
-				ConnectNulls: opts.Bool(true),
-			}))
 	}
 
-	outputPath := os.Getenv("OUTPUT_PATH")
-	if outputPath == "" {
-		outputPath = "index.html"
+	if *ogImage != "" {
+		err := writeFileAtomic(*ogImage, func(w io.Writer) error {
+			return renderOGImage(w, data, startedAt, *ogFont)
+		})
+		if err != nil {
+			log.Printf("og image error: %v", err)
+		} else {
+			log.Printf("✨ %s を生成しました", *ogImage)
+		}
 	}
-	f, err := os.Create(outputPath)
-	if err != nil {
-		log.Fatal(err)
+
+	if *manifestPath != "" {
+		m := &manifest{
+			Version:      buildVersion(),
+			StartedAt:    startedAt,
+			FinishedAt:   time.Now(),
+			Config:       flagConfig(),
+			OutputPath:   outputPath,
+			RelayResults: gen.relayResults,
+			Outputs:      written,
+		}
+		if *eventsFile == "" {
+			m.Relays = gen.relays
+		}
+		if err := writeManifest(*manifestPath, m); err != nil {
+			log.Print(err)
+			return exitError
+		}
 	}
-	defer f.Close()
 
-	if len(ranks) > 50 {
-		ranks = ranks[:50]
+	if len(publishTo) > 0 {
+		if *dryRun || *preview {
+			log.Printf("not publishing the ranking to %s with -dry-run or -preview", strings.Join(publishTo, ","))
+			return exitOK
+		}
+		ev, err := rankingEvent(data, *publishTop, secretKey, startedAt)
+		if err != nil {
+			log.Print(err)
+			return exitError
+		}
+		if publishEvent(ctx, publishTo, ev, *publishAttempts) == 0 {
+			log.Print("the ranking was not published to any relay")
+			return exitError
+		}
 	}
+	return exitOK
+}
 
-	data := pageData{
-		UpdateTime: time.Now().Format("2006年01月02日 15:04"),
-		Ranks:      ranks,
+// loadPageTemplate parses the template file at path with pageFuncs and
+// checks it defines header and footer and that both execute on an empty
+// page, so that mistakes show at startup rather than after collecting.
+func loadPageTemplate(path string) (*template.Template, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tpl, err := template.New("page").Funcs(pageFuncs).Parse(string(b))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range []string{"header", "footer"} {
+		if tpl.Lookup(name) == nil {
+			return nil, fmt.Errorf("%s does not define {{define %q}}", path, name)
+		}
+		if err := tpl.ExecuteTemplate(io.Discard, name, pageData{Lang: "ja"}); err != nil {
+			return nil, err
+		}
 	}
+	return tpl, nil
+}
 
-	renderer := &myRenderer{chart: line, data: data}
-	if err := renderer.Render(f); err != nil {
-		log.Fatal(err)
+// chartThemes are the themes go-echarts ships a script for.
+var chartThemes = []string{
+	types.ThemeChalk, types.ThemeEssos, types.ThemeInfographic, types.ThemeMacarons,
+	types.ThemePurplePassion, types.ThemeRoma, types.ThemeRomantic, types.ThemeShine,
+	types.ThemeVintage, types.ThemeWalden, types.ThemeWesteros, types.ThemeWonderland,
+}
+
+// parseTimeBound parses -since or -until: a date (2006-01-02), an RFC 3339
+// time or a duration before now such as 720h or 30d. Empty means no bound.
+func parseTimeBound(s string, now time.Time) (*nostr.Timestamp, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var t time.Time
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid number of days %q", s)
+		}
+		t = now.AddDate(0, 0, -n)
+	} else if d, err := time.ParseDuration(s); err == nil {
+		t = now.Add(-d)
+	} else if t, err = time.ParseInLocation("2006-01-02", s, time.Local); err != nil {
+		if t, err = time.Parse(time.RFC3339, s); err != nil {
+			return nil, fmt.Errorf("%q is neither a date, a time nor a duration", s)
+		}
 	}
+	ts := nostr.Timestamp(t.Unix())
+	return &ts, nil
+}
 
-	log.Println("✨ index.html が美しく生成されました！")
+// parseProxies parses the -exclude-proxies flag into lower case protocols.
+func parseProxies(s string) []string {
+	var protocols []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.ToLower(strings.TrimSpace(p)); p != "" {
+			protocols = append(protocols, p)
+		}
+	}
+	return protocols
 }
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"runtime/debug"
+	"slices"
+	"time"
+
+	"github.com/mattn/nostr-relay-ranking/ranking"
+)
+
+// manifest records how a run was produced so a published page can be
+// traced back to its inputs.
+type manifest struct {
+	Version      string                `json:"version"`
+	StartedAt    time.Time             `json:"started_at"`
+	FinishedAt   time.Time             `json:"finished_at"`
+	Config       map[string]string     `json:"config"`
+	OutputPath   string                `json:"output_path"`
+	Relays       []string              `json:"relays,omitempty"`
+	RelayResults []ranking.RelayResult `json:"relay_results,omitempty"`
+	Outputs      []manifestOutput      `json:"outputs"`
+}
+
+type manifestOutput struct {
+	Format string `json:"format"`
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+}
+
+// buildVersion returns the module version and VCS revision of the binary.
+func buildVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	version := info.Main.Version
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" {
+			version += " " + s.Value
+		}
+		if s.Key == "vcs.modified" && s.Value == "true" {
+			version += "-dirty"
+		}
+	}
+	return version
+}
+
+// secretFlags are left out of the manifest, which is usually published.
+var secretFlags = []string{"nsec"}
+
+// flagConfig returns the effective value of every flag, defaults included.
+func flagConfig() map[string]string {
+	config := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		if slices.Contains(secretFlags, f.Name) {
+			return
+		}
+		config[f.Name] = f.Value.String()
+	})
+	return config
+}
+
+func writeManifest(path string, m *manifest) error {
+	return writeFileAtomic(path, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(m)
+	})
+}
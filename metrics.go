@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics are the operational numbers -serve exposes on /metrics. They
+// live in a registry of their own, so that /metrics serves only them and
+// not the process collectors of the default registry.
+type metrics struct {
+	registry      *prometheus.Registry
+	refreshes     prometheus.Counter
+	failures      prometheus.Counter
+	relaysQueried prometheus.Gauge
+	relaysFailed  prometheus.Gauge
+	events        prometheus.Gauge
+	users         prometheus.Gauge
+	duration      prometheus.Gauge
+	lastSuccess   prometheus.Gauge
+}
+
+func newMetrics() *metrics {
+	gauge := func(name, help string) prometheus.Gauge {
+		return prometheus.NewGauge(prometheus.GaugeOpts{Name: name, Help: help})
+	}
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		refreshes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "relay_ranking_refreshes_total",
+			Help: "Collection runs started.",
+		}),
+		failures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "relay_ranking_refresh_failures_total",
+			Help: "Collection runs that did not produce a ranking.",
+		}),
+		relaysQueried: gauge("relay_ranking_relays_queried", "Relays queried by the last successful run."),
+		relaysFailed:  gauge("relay_ranking_relays_failed", "Relays that failed in the last successful run."),
+		events:        gauge("relay_ranking_events_collected", "Relay list events collected by the last successful run."),
+		users:         gauge("relay_ranking_unique_pubkeys", "Distinct users counted by the last successful run."),
+		duration:      gauge("relay_ranking_collection_duration_seconds", "Duration of the last successful run."),
+		lastSuccess:   gauge("relay_ranking_last_success_timestamp_seconds", "Unix time the last successful run finished."),
+	}
+	m.registry.MustRegister(m.refreshes, m.failures, m.relaysQueried, m.relaysFailed,
+		m.events, m.users, m.duration, m.lastSuccess)
+	return m
+}
+
+// record accounts for a refresh; gen is nil when it failed.
+func (m *metrics) record(gen *generation) {
+	m.refreshes.Inc()
+	if gen == nil {
+		m.failures.Inc()
+		return
+	}
+	queried := len(gen.relayResults)
+	m.relaysQueried.Set(float64(queried))
+	m.relaysFailed.Set(float64(queried - succeeded(gen.relayResults)))
+	m.events.Set(float64(gen.events))
+	m.users.Set(float64(gen.users))
+	m.duration.Set(gen.duration.Seconds())
+	m.lastSuccess.SetToCurrentTime()
+}
+
+// handler serves the metrics in the Prometheus exposition format.
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattn/nostr-relay-ranking/ranking"
+)
+
+func TestMetrics(t *testing.T) {
+	s := &server{metrics: newMetrics()}
+	s.metrics.record(&generation{
+		relayResults: []ranking.RelayResult{{URL: "wss://a.example"}, {URL: "wss://b.example", Error: "refused"}},
+		events:       120,
+		users:        80,
+		duration:     1500 * time.Millisecond,
+	})
+	s.metrics.record(nil)
+
+	rec := httptest.NewRecorder()
+	s.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE relay_ranking_refreshes_total counter",
+		"relay_ranking_refreshes_total 2\n",
+		"relay_ranking_refresh_failures_total 1\n",
+		"# TYPE relay_ranking_relays_queried gauge",
+		"relay_ranking_relays_queried 2\n",
+		"relay_ranking_relays_failed 1\n",
+		"relay_ranking_events_collected 120\n",
+		"relay_ranking_unique_pubkeys 80\n",
+		"relay_ranking_collection_duration_seconds 1.5\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("/metrics lacks %q:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "relay_ranking_last_success_timestamp_seconds 0\n") {
+		t.Error("last success was not recorded")
+	}
+	// Only our metrics, not the Go runtime ones of the default registry.
+	if strings.Contains(body, "go_goroutines") {
+		t.Error("/metrics serves the default registry")
+	}
+}
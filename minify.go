@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+)
+
+// rawTextTags are copied verbatim by minifyHTML since whitespace inside
+// them is significant (or is JavaScript/JSON we must not touch).
+var rawTextTags = []string{"pre", "script", "style", "textarea"}
+
+// minifyHTML collapses runs of whitespace into a single space and drops
+// the indentation between tags. Contents of rawTextTags are left alone.
+func minifyHTML(src []byte) []byte {
+	var out bytes.Buffer
+	out.Grow(len(src))
+
+	for i := 0; i < len(src); {
+		if src[i] == '<' {
+			if tag := rawTextTagAt(src[i:]); tag != "" {
+				end := indexFold(src[i:], "</"+tag)
+				if end < 0 {
+					out.Write(src[i:])
+					break
+				}
+				out.Write(src[i : i+end])
+				i += end
+				continue
+			}
+		}
+
+		if !isSpace(src[i]) {
+			out.WriteByte(src[i])
+			i++
+			continue
+		}
+
+		j := i
+		newline := false
+		for j < len(src) && isSpace(src[j]) {
+			if src[j] == '\n' {
+				newline = true
+			}
+			j++
+		}
+		betweenTags := (out.Len() == 0 || out.Bytes()[out.Len()-1] == '>') && (j == len(src) || src[j] == '<')
+		if !(newline && betweenTags) {
+			out.WriteByte(' ')
+		}
+		i = j
+	}
+	return out.Bytes()
+}
+
+func rawTextTagAt(b []byte) string {
+	for _, tag := range rawTextTags {
+		if len(b) < len(tag)+2 || !bytes.EqualFold(b[1:len(tag)+1], []byte(tag)) {
+			continue
+		}
+		if c := b[len(tag)+1]; c == '>' || isSpace(c) || c == '/' {
+			return tag
+		}
+	}
+	return ""
+}
+
+// indexFold returns the index of the lower case ASCII s in b ignoring ASCII
+// case, or -1. Bytes are compared in place, as lowering other text could
+// change its length and shift the offsets.
+func indexFold(b []byte, s string) int {
+next:
+	for i := 0; i+len(s) <= len(b); i++ {
+		for j := 0; j < len(s); j++ {
+			c := b[i+j]
+			if 'A' <= c && c <= 'Z' {
+				c += 'a' - 'A'
+			}
+			if c != s[j] {
+				continue next
+			}
+		}
+		return i
+	}
+	return -1
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
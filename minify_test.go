@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestMinifyHTML(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{
+			name: "whitespace",
+			src:  "<div>\n  <p>a   b</p>\n</div>\n",
+			want: "<div><p>a b</p></div>",
+		},
+		{
+			name: "script kept",
+			src:  "<div>\n  <script>\n  var s = \"a   b\";\n  </script>\n</div>",
+			want: "<div><script>\n  var s = \"a   b\";\n  </script></div>",
+		},
+		{
+			name: "upper case end tag",
+			src:  "<PRE>a\n  b</PRE>\n<p>c</p>",
+			want: "<PRE>a\n  b</PRE><p>c</p>",
+		},
+		{
+			// Lower casing U+212A KELVIN SIGN and U+0130 shrinks them, which
+			// must not shift where the end tag is found.
+			name: "non-ASCII",
+			src:  "<p>KİKİ</p>\n<script>var s = \"Kİ\";\n</script>\n<p>end</p>",
+			want: "<p>KİKİ</p><script>var s = \"Kİ\";\n</script><p>end</p>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(minifyHTML([]byte(tt.src))); got != tt.want {
+				t.Errorf("minifyHTML(%q) = %q, want %q", tt.src, got, tt.want)
+			}
+		})
+	}
+}
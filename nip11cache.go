@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mattn/nostr-relay-ranking/ranking"
+)
+
+type nip11Entry struct {
+	Info      ranking.RelayInfo `json:"info"`
+	FetchedAt time.Time         `json:"fetched_at"`
+}
+
+// nip11Cache keeps NIP-11 documents between runs so that relays are not
+// asked for data that rarely changes every day.
+type nip11Cache struct {
+	path    string
+	ttl     time.Duration
+	fetch   func(relayURL string) ranking.RelayInfo
+	mu      sync.Mutex
+	entries map[string]nip11Entry
+}
+
+// loadNIP11Cache reads the cache at path. A missing or corrupt file gives an
+// empty cache, it is rewritten by save.
+func loadNIP11Cache(path string, ttl time.Duration, fetch func(relayURL string) ranking.RelayInfo) *nip11Cache {
+	c := &nip11Cache{path: path, ttl: ttl, fetch: fetch, entries: make(map[string]nip11Entry)}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("read NIP-11 cache %s: %v", path, err)
+		}
+		return c
+	}
+	if err := json.Unmarshal(b, &c.entries); err != nil {
+		log.Printf("ignoring corrupt NIP-11 cache %s: %v", path, err)
+		c.entries = make(map[string]nip11Entry)
+	}
+	return c
+}
+
+// relayInfo returns the cached document of relayURL while it is younger than
+// the TTL, and fetches and stores it otherwise. Failed fetches are not
+// cached so they are retried on the next run.
+func (c *nip11Cache) relayInfo(relayURL string) ranking.RelayInfo {
+	now := time.Now()
+	c.mu.Lock()
+	e, ok := c.entries[relayURL]
+	c.mu.Unlock()
+	if ok && now.Sub(e.FetchedAt) < c.ttl {
+		return e.Info
+	}
+
+	info := c.fetch(relayURL)
+	if info.Empty() {
+		return info
+	}
+	c.mu.Lock()
+	c.entries[relayURL] = nip11Entry{Info: info, FetchedAt: now}
+	c.mu.Unlock()
+	return info
+}
+
+func (c *nip11Cache) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return writeFileAtomic(c.path, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(c.entries)
+	})
+}
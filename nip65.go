@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/mattn/nostr-relay-ranking/ranking"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// validateNIP65 checks that ev is a relay list as described in NIP-65:
+// relays are listed in r tags with an optional read or write marker.
+func validateNIP65(ev *nostr.Event) error {
+	if ev.Kind != 10002 {
+		return fmt.Errorf("unexpected kind %d", ev.Kind)
+	}
+	n := 0
+	for _, tag := range ev.Tags {
+		if len(tag) == 0 || tag[0] != "r" {
+			continue
+		}
+		if len(tag) < 2 || len(tag) > 3 {
+			return fmt.Errorf("malformed r tag %q", tag)
+		}
+		if !nostr.IsValidRelayURL(strings.TrimSpace(tag[1])) {
+			return fmt.Errorf("invalid relay URL %q", tag[1])
+		}
+		if len(tag) == 3 && tag[2] != "read" && tag[2] != "write" {
+			return fmt.Errorf("invalid marker %q", tag[2])
+		}
+		n++
+	}
+	if n == 0 {
+		return errors.New("no r tags")
+	}
+	return nil
+}
+
+// contentRelays extracts relay URLs some clients put into the content
+// instead of r tags: a kind 3 style JSON object, a JSON array, or plain
+// whitespace separated URLs.
+func contentRelays(content string) []string {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return nil
+	}
+
+	var obj map[string]json.RawMessage
+	if json.Unmarshal([]byte(content), &obj) == nil {
+		var urls []string
+		for u := range obj {
+			urls = append(urls, u)
+		}
+		return onlyRelayURLs(urls)
+	}
+	var arr []string
+	if json.Unmarshal([]byte(content), &arr) == nil {
+		return onlyRelayURLs(arr)
+	}
+	return onlyRelayURLs(strings.Fields(content))
+}
+
+func onlyRelayURLs(urls []string) []string {
+	var relays []string
+	for _, u := range urls {
+		u = strings.TrimSpace(u)
+		if nostr.IsValidRelayURL(u) {
+			relays = append(relays, u)
+		}
+	}
+	return relays
+}
+
+// applyNIP65Policy drops nonconforming events in strict mode. Otherwise it
+// keeps them, recovering relays from the content when there are no r tags.
+func applyNIP65Policy(events []*nostr.Event, strict bool) []*nostr.Event {
+	kept := events[:0]
+	invalid, recovered := 0, 0
+	for _, ev := range events {
+		err := validateNIP65(ev)
+		if err == nil {
+			kept = append(kept, ev)
+			continue
+		}
+		invalid++
+		if strict {
+			continue
+		}
+		if len(ranking.EventRelays(ev)) == 0 {
+			if urls := contentRelays(ev.Content); len(urls) > 0 {
+				for _, u := range urls {
+					ev.Tags = append(ev.Tags, nostr.Tag{"r", u})
+				}
+				filterRelayTags(ev)
+				recovered++
+			}
+		}
+		kept = append(kept, ev)
+	}
+	if strict {
+		log.Printf("✨ NIP-65 に準拠しないイベント %d/%d 件を除外しました", invalid, len(events))
+	} else {
+		log.Printf("✨ NIP-65 に準拠しないイベント %d/%d 件（content から %d 件を復元）", invalid, len(events), recovered)
+	}
+	return kept
+}
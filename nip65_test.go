@@ -0,0 +1,90 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/mattn/nostr-relay-ranking/ranking"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestValidateNIP65(t *testing.T) {
+	tests := []struct {
+		name  string
+		kind  int
+		tags  nostr.Tags
+		valid bool
+	}{
+		{"r tags", 10002, nostr.Tags{{"r", "wss://a.example"}, {"r", "wss://b.example", "read"}, {"r", "wss://c.example", "write"}}, true},
+		{"other tags ignored", 10002, nostr.Tags{{"r", "wss://a.example"}, {"client", "x", "y", "z"}}, true},
+		{"bare r tag", 10002, nostr.Tags{{"r"}}, false},
+		{"too many fields", 10002, nostr.Tags{{"r", "wss://a.example", "read", "extra"}}, false},
+		{"unknown marker", 10002, nostr.Tags{{"r", "wss://a.example", "both"}}, false},
+		{"invalid url", 10002, nostr.Tags{{"r", "https://a.example"}}, false},
+		{"no r tags", 10002, nil, false},
+		{"other kind", 3, nostr.Tags{{"r", "wss://a.example"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateNIP65(&nostr.Event{Kind: tt.kind, Tags: tt.tags})
+			if (err == nil) != tt.valid {
+				t.Errorf("validateNIP65(%v) = %v, want valid %v", tt.tags, err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestContentRelays(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{"empty", "", nil},
+		{"kind 3 object", `{"wss://a.example": {"read": true, "write": true}}`, []string{"wss://a.example"}},
+		{"array", `["wss://a.example", "not a relay", "wss://b.example"]`, []string{"wss://a.example", "wss://b.example"}},
+		{"plain text", "wss://a.example\n wss://b.example https://c.example", []string{"wss://a.example", "wss://b.example"}},
+		{"prose", "my relays are great", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := contentRelays(tt.content); !slices.Equal(got, tt.want) {
+				t.Errorf("contentRelays(%q) = %v, want %v", tt.content, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyNIP65Policy(t *testing.T) {
+	events := func() []*nostr.Event {
+		return []*nostr.Event{
+			{ID: "ok", PubKey: "alice", Kind: 10002, Tags: nostr.Tags{{"r", "wss://a.example"}}},
+			{ID: "content", PubKey: "bob", Kind: 10002, Content: `["wss://b.example"]`},
+			{ID: "marker", PubKey: "carol", Kind: 10002, Tags: nostr.Tags{{"r", "wss://c.example", "both"}}},
+		}
+	}
+	ids := func(events []*nostr.Event) []string {
+		var ids []string
+		for _, ev := range events {
+			ids = append(ids, ev.ID)
+		}
+		return ids
+	}
+
+	strict := applyNIP65Policy(events(), true)
+	if got := ids(strict); !slices.Equal(got, []string{"ok"}) {
+		t.Errorf("strict mode kept %v, want only the conforming event", got)
+	}
+
+	lenient := applyNIP65Policy(events(), false)
+	if got := ids(lenient); !slices.Equal(got, []string{"ok", "content", "marker"}) {
+		t.Fatalf("lenient mode kept %v, want every event", got)
+	}
+	if got := ranking.EventRelays(lenient[1]); !slices.Equal(got, []string{"wss://b.example"}) {
+		t.Errorf("relays recovered from the content = %v, want wss://b.example", got)
+	}
+	// A malformed marker still counts the relay in lenient mode.
+	if got := ranking.EventRelays(lenient[2]); !slices.Equal(got, []string{"wss://c.example"}) {
+		t.Errorf("relays of the event with a bad marker = %v, want wss://c.example", got)
+	}
+}
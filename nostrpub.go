@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// The ranking is published as NIP-78 application data, a replaceable event
+// that clients look up by author, kind and d tag.
+const (
+	rankingEventKind = 30078
+	rankingDTag      = "nostr-relay-ranking"
+)
+
+// rankingEvent returns the signed event carrying the top n ranks of data as
+// JSON, in the shape of the json format.
+func rankingEvent(data pageData, n int, sk string, createdAt time.Time) (*nostr.Event, error) {
+	ranks := jsonRanks(data)
+	content, err := json.Marshal(struct {
+		UpdatedAt int64      `json:"updated_at"`
+		Users     int        `json:"users"`
+		Mode      string     `json:"mode"`
+		Ranks     []jsonRank `json:"ranks"`
+	}{createdAt.Unix(), data.SampleSize, data.Mode, ranks[:min(n, len(ranks))]})
+	if err != nil {
+		return nil, err
+	}
+	ev := &nostr.Event{
+		Kind:      rankingEventKind,
+		CreatedAt: nostr.Timestamp(createdAt.Unix()),
+		Tags:      nostr.Tags{{"d", rankingDTag}, {"alt", data.SiteTitle}},
+		Content:   string(content),
+	}
+	if err := ev.Sign(sk); err != nil {
+		return nil, err
+	}
+	return ev, nil
+}
+
+// publishEvent sends ev to each relay, trying a relay up to attempts times
+// with a growing pause in between. It returns how many relays accepted it.
+func publishEvent(ctx context.Context, relays []string, ev *nostr.Event, attempts int) int {
+	accepted := 0
+	for _, url := range relays {
+		var err error
+		for attempt := 1; attempt <= attempts; attempt++ {
+			if err = publishOnce(ctx, url, ev); err == nil {
+				break
+			}
+			log.Printf("publish to %s failed (attempt %d/%d): %v", url, attempt, attempts, err)
+			if attempt < attempts {
+				select {
+				case <-ctx.Done():
+					return accepted
+				case <-time.After(time.Duration(attempt) * 2 * time.Second):
+				}
+			}
+		}
+		if err == nil {
+			log.Printf("✨ ランキングを %s に公開しました (event %s)", url, ev.ID)
+			accepted++
+		}
+	}
+	return accepted
+}
+
+func publishOnce(ctx context.Context, url string, ev *nostr.Event) error {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	relay, err := nostr.RelayConnect(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer relay.Close()
+	return relay.Publish(ctx, *ev)
+}
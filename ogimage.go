@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	ogWidth  = 1200
+	ogHeight = 630
+)
+
+var (
+	ogIndigo = color.RGBA{0x4f, 0x46, 0xe5, 0xff}
+	ogGray   = color.RGBA{0x6b, 0x72, 0x80, 0xff}
+	ogText   = color.RGBA{0x11, 0x18, 0x27, 0xff}
+)
+
+// loadOGFonts returns the regular and bold font used on the card. The
+// bundled Go fonts only cover Latin text, so a font with Japanese glyphs
+// (e.g. Noto Sans JP) has to be given to render Japanese titles.
+func loadOGFonts(path string) (regular, bold *opentype.Font, err error) {
+	if path == "" {
+		if regular, err = opentype.Parse(goregular.TTF); err != nil {
+			return nil, nil, err
+		}
+		if bold, err = opentype.Parse(gobold.TTF); err != nil {
+			return nil, nil, err
+		}
+		return regular, bold, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if regular, err = opentype.Parse(b); err != nil {
+		if coll, cerr := opentype.ParseCollection(b); cerr == nil && coll.NumFonts() > 0 {
+			regular, err = coll.Font(0)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return regular, regular, nil
+}
+
+func ogFace(f *opentype.Font, size float64) (font.Face, error) {
+	return opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+}
+
+// fitText shortens s with an ellipsis until it fits into width pixels.
+func fitText(face font.Face, s string, width int) string {
+	if font.MeasureString(face, s).Ceil() <= width {
+		return s
+	}
+	r := []rune(s)
+	for len(r) > 0 {
+		r = r[:len(r)-1]
+		t := string(r) + "…"
+		if font.MeasureString(face, t).Ceil() <= width {
+			return t
+		}
+	}
+	return ""
+}
+
+func drawText(dst draw.Image, face font.Face, c color.Color, x, y int, s string) {
+	d := &font.Drawer{
+		Dst:  dst,
+		Src:  image.NewUniform(c),
+		Face: face,
+		Dot:  fixed.P(x, y),
+	}
+	d.DrawString(s)
+}
+
+// renderOGImage draws a share card with the top 5 relays of the ranking.
+// The date is written numerically so that it renders with any font.
+func renderOGImage(w io.Writer, data pageData, updated time.Time, fontPath string) error {
+	regular, bold, err := loadOGFonts(fontPath)
+	if err != nil {
+		return err
+	}
+	titleFace, err := ogFace(bold, 56)
+	if err != nil {
+		return err
+	}
+	defer titleFace.Close()
+	dateFace, err := ogFace(regular, 26)
+	if err != nil {
+		return err
+	}
+	defer dateFace.Close()
+	rowFace, err := ogFace(regular, 36)
+	if err != nil {
+		return err
+	}
+	defer rowFace.Close()
+	countFace, err := ogFace(bold, 36)
+	if err != nil {
+		return err
+	}
+	defer countFace.Close()
+
+	img := image.NewRGBA(image.Rect(0, 0, ogWidth, ogHeight))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, 0, ogWidth, 130), image.NewUniform(ogIndigo), image.Point{}, draw.Src)
+
+	const margin = 60
+	drawText(img, titleFace, color.White, margin, 88, fitText(titleFace, data.SiteTitle, ogWidth-2*margin))
+	drawText(img, dateFace, ogGray, margin, 180, updated.Format("2006-01-02 15:04"))
+
+	for i, r := range data.Ranks {
+		if i >= 5 {
+			break
+		}
+		y := 250 + i*78
+		count := formatCount(r.Count)
+		countWidth := font.MeasureString(countFace, count).Ceil()
+		drawText(img, countFace, ogIndigo, ogWidth-margin-countWidth, y, count)
+
+		rank := fmt.Sprintf("%d.", i+1)
+		drawText(img, countFace, ogText, margin, y, rank)
+		host := strings.TrimPrefix(strings.TrimPrefix(r.Name, "wss://"), "ws://")
+		drawText(img, rowFace, ogText, margin+70, y, fitText(rowFace, host, ogWidth-2*margin-70-countWidth-40))
+	}
+	return png.Encode(w, img)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// writeFileAtomic renders into a temporary file next to path and renames it
+// into place, so readers never see a half written file.
+func writeFileAtomic(path string, render func(w io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if err := render(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Chmod(tmp, 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// previewFile returns where the preview of an output file is written:
+// index.html becomes index.preview.html.
+func previewFile(path string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + ".preview" + ext
+}
+
+// promote moves previously generated previews over the production files.
+func promote(outputs []string, outputPath, htmlPreview string) error {
+	promoted := 0
+	for _, name := range outputs {
+		if outputFormats[name].filename == "" {
+			continue
+		}
+		dst := outputFile(name, outputPath)
+		src := previewFile(dst)
+		if name == "html" && htmlPreview != "" {
+			src = htmlPreview
+		}
+		if _, err := os.Stat(src); err != nil {
+			if os.IsNotExist(err) {
+				log.Printf("no preview for %s, skipping", dst)
+				continue
+			}
+			return err
+		}
+		if err := os.Rename(src, dst); err != nil {
+			return err
+		}
+		log.Printf("✨ %s を %s に公開しました", src, dst)
+		promoted++
+	}
+	if promoted == 0 {
+		return fmt.Errorf("no preview files found")
+	}
+	return nil
+}
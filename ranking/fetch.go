@@ -0,0 +1,493 @@
+package ranking
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// ErrAuthRequired is returned when a relay closes a subscription with an
+// auth-required: reason (NIP-42).
+var ErrAuthRequired = errors.New("relay requires authentication")
+
+// FetchEvents pages through the events of opts.Kinds, kind 10002 unless
+// set, of an already connected relay. The caller owns the connection, so
+// that every query made during a run shares it.
+func FetchEvents(ctx context.Context, relay *nostr.Relay, opts FetchOptions) ([]*nostr.Event, error) {
+	max := opts.MaxEvents
+	allEvents := make([]*nostr.Event, 0, max)
+	limit := 500
+	until := opts.Until
+	seen := make(map[string]struct{})
+	authed := false
+	kinds := opts.Kinds
+	if len(kinds) == 0 {
+		kinds = []int{10002}
+	}
+
+	for {
+		filter := nostr.Filter{Kinds: kinds, Limit: limit, Since: opts.Since}
+		if until != nil {
+			filter.Until = until
+		}
+
+		var events []*nostr.Event
+		var err error
+		if opts.Subscribe {
+			events, err = streamEvents(ctx, relay, filter, opts.HardEventCap, opts.EOSEGrace)
+		} else {
+			events, err = queryEvents(ctx, relay, filter, opts.HardEventCap)
+		}
+		if errors.Is(err, ErrAuthRequired) {
+			if opts.SecretKey == "" {
+				log.Printf("%s requires NIP-42 authentication, skipping it (set -nsec to authenticate)", relay.URL)
+				return nil, err
+			}
+			if authed {
+				return nil, err
+			}
+			authed = true
+			if err := relay.Auth(ctx, func(ev *nostr.Event) error { return ev.Sign(opts.SecretKey) }); err != nil {
+				return nil, fmt.Errorf("NIP-42 auth: %w", err)
+			}
+			log.Printf("%s: authenticated (NIP-42)", relay.URL)
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			log.Printf("%s: query failed (%v), falling back to subscription", relay.URL, err)
+			events, err = subscribeEvents(ctx, relay, filter, opts.HardEventCap)
+			if err != nil {
+				return nil, err
+			}
+			log.Printf("%s: subscription fallback recovered %d events", relay.URL, len(events))
+		}
+
+		// Some relays send an event twice in one response: drop repeats by
+		// ID before the filter or any per-relay count sees them.
+		for _, ev := range events {
+			if _, dup := seen[ev.ID]; dup {
+				continue
+			}
+			seen[ev.ID] = struct{}{}
+			if opts.Filter != nil {
+				opts.Filter(ev)
+			}
+			allEvents = append(allEvents, ev)
+		}
+
+		if len(allEvents) >= max {
+			allEvents = allEvents[:max]
+			break
+		}
+
+		if len(events) < limit {
+			break
+		}
+
+		var oldest nostr.Timestamp = nostr.Now()
+		for _, ev := range events {
+			if ev.CreatedAt < oldest {
+				oldest = ev.CreatedAt
+			}
+		}
+		// Pages overlap on events sharing the oldest timestamp otherwise;
+		// the seen set still drops duplicates a relay sends anyway.
+		oldest--
+		until = &oldest
+	}
+
+	return allEvents, nil
+}
+
+// queryEvents reads the stored events matching filter up to EOSE. It stops
+// once hardCap events arrived, so a relay ignoring the filter limit can not
+// exhaust memory, and reports relays asking for NIP-42 authentication with
+// ErrAuthRequired. Other failures make FetchEvents retry the page with
+// subscribeEvents.
+func queryEvents(ctx context.Context, relay *nostr.Relay, filter nostr.Filter, hardCap int) ([]*nostr.Event, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	sub, err := relay.Subscribe(ctx, nostr.Filters{filter})
+	if err != nil {
+		return nil, err
+	}
+	defer sub.Unsub()
+	return readSubscription(ctx, relay, sub, filter, hardCap)
+}
+
+// subscribeEvents is the fallback for relays queryEvents can not cope with.
+// It opens a fresh subscription, gives the relay at most 10 seconds and
+// returns whatever arrived even on timeout, instead of losing the relay's
+// contribution to one bad response.
+func subscribeEvents(ctx context.Context, relay *nostr.Relay, filter nostr.Filter, hardCap int) ([]*nostr.Event, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	sub, err := relay.Subscribe(ctx, nostr.Filters{filter})
+	if err != nil {
+		return nil, err
+	}
+	defer sub.Unsub()
+	return readSubscription(ctx, relay, sub, filter, hardCap)
+}
+
+// readSubscription reads events from sub until EOSE, the relay closes it or
+// ctx is done.
+func readSubscription(ctx context.Context, relay *nostr.Relay, sub *nostr.Subscription, filter nostr.Filter, hardCap int) ([]*nostr.Event, error) {
+	events := make([]*nostr.Event, 0, filter.Limit)
+	for {
+		select {
+		case ev, ok := <-sub.Events:
+			if !ok {
+				return events, nil
+			}
+			events = append(events, ev)
+			if len(events) >= hardCap {
+				log.Printf("%s: hard event cap %d reached, the relay ignores the requested limit %d", relay.URL, hardCap, filter.Limit)
+				return events, nil
+			}
+		case <-sub.EndOfStoredEvents:
+			for {
+				select {
+				case ev, ok := <-sub.Events:
+					if !ok {
+						return events, nil
+					}
+					events = append(events, ev)
+				default:
+					return events, nil
+				}
+			}
+		case reason := <-sub.ClosedReason:
+			return events, closedError(relay, reason)
+		case <-ctx.Done():
+			return events, nil
+		}
+	}
+}
+
+// streamEvents is the -subscribe path for relays that send stored events
+// slowly: it keeps reading for grace after EOSE instead of stopping there,
+// and logs how many events came in late.
+func streamEvents(ctx context.Context, relay *nostr.Relay, filter nostr.Filter, hardCap int, grace time.Duration) ([]*nostr.Event, error) {
+	sub, err := relay.Subscribe(ctx, nostr.Filters{filter})
+	if err != nil {
+		return nil, err
+	}
+	defer sub.Unsub()
+
+	events := make([]*nostr.Event, 0, filter.Limit)
+	eose := sub.EndOfStoredEvents
+	var graceEnd <-chan time.Time
+	atEOSE := 0
+	for {
+		select {
+		case ev, ok := <-sub.Events:
+			if !ok {
+				return events, nil
+			}
+			events = append(events, ev)
+			if len(events) >= hardCap {
+				log.Printf("%s: hard event cap %d reached, the relay ignores the requested limit %d", relay.URL, hardCap, filter.Limit)
+				return events, nil
+			}
+		case <-eose:
+			eose = nil
+			atEOSE = len(events)
+			timer := time.NewTimer(grace)
+			defer timer.Stop()
+			graceEnd = timer.C
+		case <-graceEnd:
+			log.Printf("%s: %d events by EOSE, %d more within the %s grace period", relay.URL, atEOSE, len(events)-atEOSE, grace)
+			return events, nil
+		case reason := <-sub.ClosedReason:
+			return events, closedError(relay, reason)
+		case <-ctx.Done():
+			if eose != nil {
+				log.Printf("%s: no EOSE before the deadline, %d events", relay.URL, len(events))
+			}
+			return events, nil
+		}
+	}
+}
+
+// closedError turns the reason of a CLOSED message into ErrAuthRequired
+// for NIP-42 relays, and logs any other reason.
+func closedError(relay *nostr.Relay, reason string) error {
+	if strings.HasPrefix(reason, "auth-required:") {
+		return fmt.Errorf("%w: %s", ErrAuthRequired, reason)
+	}
+	log.Printf("%s: subscription closed: %s", relay.URL, reason)
+	return nil
+}
+
+// FetchOptions controls how events are collected from relays.
+type FetchOptions struct {
+	MaxEvents    int           // total events to page through per relay
+	HardEventCap int           // events accepted from a single subscription
+	CrawlBudget  time.Duration // stop waiting for relays after this long, 0 for no budget
+	RelayTimeout time.Duration // deadline for connecting to and querying a single relay
+	SecretKey    string        // hex key answering NIP-42 challenges, empty to skip such relays
+	Subscribe    bool          // keep reading for EOSEGrace after EOSE (streamEvents)
+	EOSEGrace    time.Duration
+	Concurrency  int                // relays connected at the same time
+	PerHost      int                // relays of one site (see relaySite) connected at the same time, 0 for no limit
+	Filter       func(*nostr.Event) // called on every new event, e.g. to drop r tags
+	Verbose      bool               // log how each relay's events fared in dedup
+	Kinds        []int              // event kinds queried, kind 10002 when empty
+	Limits       map[string]int     // per relay overrides of MaxEvents, keyed by URL
+	Since, Until *nostr.Timestamp   // bounds of created_at queried, nil for none
+}
+
+// DefaultFetchOptions are the options used by the command line tool.
+var DefaultFetchOptions = FetchOptions{
+	MaxEvents:    10000,
+	HardEventCap: 5000,
+	RelayTimeout: 10 * time.Second,
+	EOSEGrace:    2 * time.Second,
+	Concurrency:  16,
+	PerHost:      2,
+}
+
+// collectTimeout caps a whole crawl. Each relay also gets its own
+// RelayTimeout so that one hung relay can not use up the whole window.
+const collectTimeout = 20 * time.Second
+
+// stopTimeout is how long an interrupted crawl waits for relays to close.
+const stopTimeout = 5 * time.Second
+
+// RelayResult describes how fetching from one relay went.
+type RelayResult struct {
+	URL      string        `json:"url"`
+	Events   int           `json:"events"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration_ns"`
+	TimedOut bool          `json:"timed_out,omitempty"`
+}
+
+// CollectEvents queries all relays concurrently and returns the events
+// together with a result per relay, in the order of relays. With a crawl
+// budget it returns once the budget elapsed, keeping the relays finished
+// so far and marking the others as timed out. Cancelling parent aborts the
+// queries in flight; relays that do not stop within stopTimeout are given
+// up on.
+func CollectEvents(parent context.Context, relays []string, opts FetchOptions) ([]*nostr.Event, []RelayResult) {
+	ctx, cancel := context.WithTimeout(parent, max(collectTimeout, opts.RelayTimeout))
+	defer cancel()
+
+	var all []*nostr.Event
+	perRelay := make([][]*nostr.Event, len(relays))
+	results := make([]RelayResult, len(relays))
+	finished := make([]bool, len(relays))
+	harvested := false
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	// sem bounds the number of relays connected at the same time, siteSems
+	// the number per site.
+	sem := make(chan struct{}, opts.Concurrency)
+	siteSems := make(map[string]chan struct{})
+	if opts.PerHost > 0 {
+		for _, rurl := range relays {
+			if site := relaySite(rurl); siteSems[site] == nil {
+				siteSems[site] = make(chan struct{}, opts.PerHost)
+			}
+		}
+	}
+
+	start := time.Now()
+	for i, relay := range relays {
+		wg.Add(1)
+		go func(i int, rurl string) {
+			defer wg.Done()
+
+			res := RelayResult{URL: rurl}
+			var events []*nostr.Event
+			defer func() {
+				res.Duration = time.Since(start)
+				mu.Lock()
+				defer mu.Unlock()
+				if harvested {
+					return
+				}
+				results[i] = res
+				finished[i] = true
+				perRelay[i] = events
+				all = append(all, events...)
+			}()
+
+			// Waiting for the site first keeps relays of a busy site from
+			// holding global slots other relays could use.
+			if siteSem := siteSems[relaySite(rurl)]; siteSem != nil {
+				select {
+				case siteSem <- struct{}{}:
+					defer func() { <-siteSem }()
+				case <-ctx.Done():
+					log.Printf("%s: no connection slot for its host before the deadline", rurl)
+					res.Error = ctx.Err().Error()
+					return
+				}
+			}
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				log.Printf("%s: no connection slot before the deadline", rurl)
+				res.Error = ctx.Err().Error()
+				return
+			}
+
+			rctx, rcancel := context.WithTimeout(ctx, opts.RelayTimeout)
+			defer rcancel()
+			defer func() {
+				switch {
+				case rctx.Err() != nil && parent.Err() != nil:
+					// The query stopped early with whatever had arrived;
+					// it must not pass for a relay that answered.
+					res.Error = "interrupted"
+				case rctx.Err() == context.DeadlineExceeded && ctx.Err() == nil:
+					log.Printf("%s hit the relay timeout of %s", rurl, opts.RelayTimeout)
+					res.TimedOut = true
+				}
+			}()
+
+			relay, err := nostr.RelayConnect(rctx, rurl)
+			if err != nil {
+				log.Printf("connect error %s: %v", rurl, err)
+				res.Error = err.Error()
+				return
+			}
+			defer relay.Close()
+
+			ropts := opts
+			if limit, ok := opts.Limits[rurl]; ok {
+				ropts.MaxEvents = limit
+			}
+			events, err = FetchEvents(rctx, relay, ropts)
+			if err != nil {
+				log.Printf("query error %s: %v", rurl, err)
+				res.Error = err.Error()
+				return
+			}
+			res.Events = len(events)
+			log.Printf("%s → %d events", rurl, len(events))
+		}(i, relay)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	var budget <-chan time.Time
+	if opts.CrawlBudget > 0 {
+		timer := time.NewTimer(opts.CrawlBudget)
+		defer timer.Stop()
+		budget = timer.C
+	}
+	reason := "timed out this run"
+	select {
+	case <-done:
+	case <-budget:
+	case <-parent.Done():
+		reason = "interrupted"
+		select {
+		case <-done:
+		case <-time.After(stopTimeout):
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	harvested = true
+	completed := 0
+	for i, rurl := range relays {
+		if finished[i] {
+			completed++
+			continue
+		}
+		results[i] = RelayResult{
+			URL:      rurl,
+			Error:    reason,
+			Duration: time.Since(start),
+			TimedOut: parent.Err() == nil,
+		}
+		if parent.Err() != nil {
+			log.Printf("%s did not stop within %s", rurl, stopTimeout)
+		} else {
+			log.Printf("%s did not finish within the crawl budget", rurl)
+		}
+	}
+	if opts.CrawlBudget > 0 {
+		log.Printf("✨ クロール予算 %s 内に %d/%d 件のリレーが完了しました", opts.CrawlBudget, completed, len(relays))
+	}
+	if opts.Verbose {
+		logDedupBreakdown(relays, perRelay, LatestEvents(all))
+	}
+	return all, results
+}
+
+// relaySite returns what relays sharing infrastructure likely have in
+// common: the last two labels of the host name, or three under a second
+// level domain such as co.jp, and IP addresses as they are.
+func relaySite(rurl string) string {
+	u, err := url.Parse(rurl)
+	if err != nil {
+		return rurl
+	}
+	host := strings.ToLower(u.Hostname())
+	if net.ParseIP(host) != nil {
+		return host
+	}
+	labels := strings.Split(host, ".")
+	n := 2
+	if len(labels) >= 3 && len(labels[len(labels)-1]) == 2 && slices.Contains(secondLevelLabels, labels[len(labels)-2]) {
+		n = 3
+	}
+	return strings.Join(labels[max(0, len(labels)-n):], ".")
+}
+
+// secondLevelLabels are the labels under a country code TLD that are
+// registries rather than sites, as in example.co.jp.
+var secondLevelLabels = []string{"ac", "co", "com", "ed", "go", "gr", "lg", "ne", "net", "or", "org"}
+
+// logDedupBreakdown logs, per relay, how many of its events survived dedup
+// and how many were superseded by newer ones from other relays. Surviving
+// events no other relay returned are counted as unique, which tells relays
+// contributing their own data apart from ones echoing the rest.
+func logDedupBreakdown(relays []string, perRelay [][]*nostr.Event, latest map[string]*nostr.Event) {
+	holders := make(map[string]int)
+	for _, events := range perRelay {
+		for _, ev := range events {
+			holders[ev.ID]++
+		}
+	}
+	for i, events := range perRelay {
+		if len(events) == 0 {
+			continue
+		}
+		var kept, unique int
+		for _, ev := range events {
+			if latest[dedupKey(ev)].ID != ev.ID {
+				continue
+			}
+			kept++
+			if holders[ev.ID] == 1 {
+				unique++
+			}
+		}
+		log.Printf("%s: %d latest (%d unique), %d superseded", relays[i], kept, unique, len(events)-kept)
+	}
+}
@@ -0,0 +1,255 @@
+package ranking
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mattn/nostr-relay-ranking/ranking/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// relayList returns a kind 10002 event of a new user listing relays.
+func relayList(t *testing.T, createdAt nostr.Timestamp, relays ...string) *nostr.Event {
+	t.Helper()
+	ev, err := relaytest.RelayList(nostr.GeneratePrivateKey(), createdAt, relays...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ev
+}
+
+func TestCollectEventsFailingRelay(t *testing.T) {
+	good := relaytest.NewRelay(
+		relayList(t, 1700000000, "wss://a.example"),
+		relayList(t, 1700000001, "wss://a.example", "wss://b.example"),
+	)
+	defer good.Close()
+	down := relaytest.NewRelay()
+	down.Close()
+
+	events, results := CollectEvents(context.Background(), []string{down.URL, good.URL}, DefaultFetchOptions)
+	if len(events) != 2 {
+		t.Errorf("got %d events, want the 2 of the relay that answered", len(events))
+	}
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want one per relay", len(results))
+	}
+	if r := results[0]; r.URL != down.URL || r.Error == "" || r.Events != 0 {
+		t.Errorf("failing relay: got %+v, want an error", r)
+	}
+	if r := results[1]; r.URL != good.URL || r.Error != "" || r.Events != 2 {
+		t.Errorf("answering relay: got %+v, want 2 events and no error", r)
+	}
+}
+
+func TestCollectEventsReusesConnection(t *testing.T) {
+	// More events than one page of FetchEvents holds, so that it has to
+	// query the relay again.
+	sk := nostr.GeneratePrivateKey()
+	relay := relaytest.NewRelay()
+	defer relay.Close()
+	for i := range 600 {
+		ev, err := relaytest.RelayList(sk, nostr.Timestamp(1700000000+i), "wss://a.example")
+		if err != nil {
+			t.Fatal(err)
+		}
+		relay.Publish(ev)
+	}
+
+	events, results := CollectEvents(context.Background(), []string{relay.URL}, DefaultFetchOptions)
+	if results[0].Error != "" {
+		t.Fatal(results[0].Error)
+	}
+	if len(events) != 600 {
+		t.Errorf("got %d events, want 600", len(events))
+	}
+	if n := relay.Load.Queries(); n < 2 {
+		t.Errorf("relay was queried %d times, want the events paged over several queries", n)
+	}
+	if n := relay.Load.Connections(); n != 1 {
+		t.Errorf("opened %d connections, want 1 shared by all queries", n)
+	}
+}
+
+func TestFetchEventsPaginates(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	var stored []*nostr.Event
+	for i := range 1200 {
+		ev, err := relaytest.RelayList(sk, nostr.Timestamp(1700000000+i), "wss://a.example")
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored = append(stored, ev)
+	}
+
+	tests := []struct {
+		name        string
+		maxEvents   int
+		wantEvents  int
+		wantQueries int
+	}{
+		{"all pages", 10000, 1200, 3},
+		{"capped by max-events", 700, 700, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			relay := relaytest.NewRelay(stored...)
+			defer relay.Close()
+			conn, err := nostr.RelayConnect(context.Background(), relay.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			opts := DefaultFetchOptions
+			opts.MaxEvents = tt.maxEvents
+			events, err := FetchEvents(context.Background(), conn, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(events) != tt.wantEvents {
+				t.Errorf("got %d events, want %d", len(events), tt.wantEvents)
+			}
+			ids := make(map[string]bool)
+			for _, ev := range events {
+				if ids[ev.ID] {
+					t.Fatalf("event %s returned twice", ev.ID)
+				}
+				ids[ev.ID] = true
+			}
+			if n := relay.Load.Queries(); n != tt.wantQueries {
+				t.Errorf("relay was queried %d times, want %d", n, tt.wantQueries)
+			}
+		})
+	}
+}
+
+// slowRelays starts n relays sharing one Load that each answer after
+// delay with a relay list.
+func slowRelays(t *testing.T, n int, delay time.Duration) ([]string, *relaytest.Load) {
+	t.Helper()
+	load := new(relaytest.Load)
+	var urls []string
+	for range n {
+		relay := relaytest.NewRelay(relayList(t, 1700000000, "wss://a.example"))
+		relay.Delay = delay
+		relay.Load = load
+		t.Cleanup(relay.Close)
+		urls = append(urls, relay.URL)
+	}
+	return urls, load
+}
+
+func TestCollectEventsConcurrency(t *testing.T) {
+	urls, load := slowRelays(t, 6, 50*time.Millisecond)
+	opts := DefaultFetchOptions
+	opts.Concurrency = 2
+	opts.PerHost = 0
+
+	events, results := CollectEvents(context.Background(), urls, opts)
+	for _, r := range results {
+		if r.Error != "" {
+			t.Fatalf("%s: %s", r.URL, r.Error)
+		}
+	}
+	if len(events) != 6 {
+		t.Errorf("got %d events, want one per relay", len(events))
+	}
+	if n := load.Connections(); n != 6 {
+		t.Errorf("dialed %d times, want once per relay", n)
+	}
+	if n := load.Peak(); n > 2 {
+		t.Errorf("%d relays were queried at once, want at most -concurrency 2", n)
+	}
+}
+
+func TestCollectEventsCancel(t *testing.T) {
+	// The relay would answer long after the test gave up.
+	urls, load := slowRelays(t, 1, time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		for load.Queries() == 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		cancel()
+	}()
+
+	start := time.Now()
+	events, results := CollectEvents(ctx, urls, DefaultFetchOptions)
+	if d := time.Since(start); d > stopTimeout {
+		t.Errorf("returned %s after the cancel, want within the stop timeout of %s", d, stopTimeout)
+	}
+	if len(events) != 0 {
+		t.Errorf("got %d events from a relay that never answered", len(events))
+	}
+	if r := results[0]; r.Error != "interrupted" || r.TimedOut {
+		t.Errorf("got %+v, want the relay reported as interrupted", r)
+	}
+}
+
+func TestCollectEventsDuplicates(t *testing.T) {
+	ev := relayList(t, 1700000000, "wss://a.example")
+	twice := relaytest.NewRelay(ev)
+	twice.Duplicates = true
+	defer twice.Close()
+	again := relaytest.NewRelay(ev)
+	defer again.Close()
+
+	events, results := CollectEvents(context.Background(), []string{twice.URL, again.URL}, DefaultFetchOptions)
+	for _, r := range results {
+		if r.Error != "" {
+			t.Fatalf("%s: %s", r.URL, r.Error)
+		}
+		if r.Events != 1 {
+			t.Errorf("%s: counted %d events, want the repeated event once", r.URL, r.Events)
+		}
+	}
+	if n := UniqueUsers(events); n != 1 {
+		t.Errorf("UniqueUsers = %d, want 1", n)
+	}
+	if got := TallyRelays(events); got["wss://a.example"] != 1 || len(got) != 1 {
+		t.Errorf("TallyRelays = %v, want the user counted once", got)
+	}
+}
+
+func TestCollectEventsPerHost(t *testing.T) {
+	// All test relays listen on 127.0.0.1, so they are one site.
+	urls, load := slowRelays(t, 6, 50*time.Millisecond)
+	opts := DefaultFetchOptions
+	opts.Concurrency = 16
+	opts.PerHost = 2
+
+	events, results := CollectEvents(context.Background(), urls, opts)
+	for _, r := range results {
+		if r.Error != "" {
+			t.Fatalf("%s: %s", r.URL, r.Error)
+		}
+	}
+	if len(events) != 6 {
+		t.Errorf("got %d events, want one per relay", len(events))
+	}
+	if n := load.Peak(); n > 2 {
+		t.Errorf("%d relays of one host were queried at once, want at most -concurrency-per-host 2", n)
+	}
+}
+
+func TestRelaySite(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"wss://relay.example.com", "example.com"},
+		{"wss://a.b.Example.COM:7777/path", "example.com"},
+		{"wss://example.com", "example.com"},
+		{"wss://relay.example.co.jp", "example.co.jp"},
+		{"wss://relay.example.ne.jp", "example.ne.jp"},
+		{"wss://relay.example.jp", "example.jp"},
+		{"wss://co.jp", "co.jp"},
+		{"ws://127.0.0.1:8080", "127.0.0.1"},
+		{"wss://[2001:db8::1]:443", "2001:db8::1"},
+		{"wss://localhost", "localhost"},
+	}
+	for _, tt := range tests {
+		if got := relaySite(tt.in); got != tt.want {
+			t.Errorf("relaySite(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package ranking
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Kind3RelayTags parses the relay map legacy clients put into the content
+// of a kind 3 contact list, {"wss://relay.example": {"read": true, "write":
+// false}, ...}, into NIP-65 r tags. A relay used both ways gets no marker,
+// one used neither way and invalid URLs are left out.
+func Kind3RelayTags(content string) (nostr.Tags, error) {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return nil, nil
+	}
+	var relays map[string]struct {
+		Read  bool `json:"read"`
+		Write bool `json:"write"`
+	}
+	if err := json.Unmarshal([]byte(content), &relays); err != nil {
+		return nil, err
+	}
+	urls := make([]string, 0, len(relays))
+	for u := range relays {
+		urls = append(urls, u)
+	}
+	sort.Strings(urls)
+
+	var tags nostr.Tags
+	for _, u := range urls {
+		p := relays[u]
+		u = strings.TrimSpace(u)
+		if !nostr.IsValidRelayURL(u) {
+			continue
+		}
+		switch {
+		case p.Read && p.Write:
+			tags = append(tags, nostr.Tag{"r", u})
+		case p.Read:
+			tags = append(tags, nostr.Tag{"r", u, "read"})
+		case p.Write:
+			tags = append(tags, nostr.Tag{"r", u, "write"})
+		}
+	}
+	return tags, nil
+}
+
+// FoldKind3 replaces the kind 3 events among events by kind 10002 events
+// carrying the relays of their content, so that the tally dedups them by
+// pubkey against real relay lists and keeps whichever is newer. Contact
+// lists without relays are dropped. filter, if set, is applied to the
+// converted events like to fetched ones.
+func FoldKind3(events []*nostr.Event, filter func(*nostr.Event)) []*nostr.Event {
+	kept := events[:0]
+	for _, ev := range events {
+		if ev.Kind != 3 {
+			kept = append(kept, ev)
+			continue
+		}
+		tags, err := Kind3RelayTags(ev.Content)
+		if err != nil || len(tags) == 0 {
+			continue
+		}
+		converted := &nostr.Event{
+			ID:        ev.ID,
+			PubKey:    ev.PubKey,
+			CreatedAt: ev.CreatedAt,
+			Kind:      10002,
+			Tags:      tags,
+		}
+		if filter != nil {
+			filter(converted)
+		}
+		kept = append(kept, converted)
+	}
+	return kept
+}
@@ -0,0 +1,88 @@
+package ranking
+
+import (
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestKind3RelayTags(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    nostr.Tags
+		wantErr bool
+	}{
+		{"empty", "", nil, false},
+		{"blank", "  \n", nil, false},
+		{"no relays", "{}", nil, false},
+		{
+			name: "markers",
+			content: `{
+				"wss://c.example": {"read": true, "write": true},
+				"wss://a.example": {"read": true, "write": false},
+				"wss://b.example": {"read": false, "write": true}
+			}`,
+			want: nostr.Tags{
+				{"r", "wss://a.example", "read"},
+				{"r", "wss://b.example", "write"},
+				{"r", "wss://c.example"},
+			},
+		},
+		{
+			name:    "unused and invalid relays",
+			content: `{"wss://a.example": {}, "https://b.example": {"read": true}, " wss://c.example ": {"write": true}}`,
+			want:    nostr.Tags{{"r", "wss://c.example", "write"}},
+		},
+		{"not json", "not json", nil, true},
+		{"not an object", `["wss://a.example"]`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Kind3RelayTags(tt.content)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Kind3RelayTags error = %v, want error %v", err, tt.wantErr)
+			}
+			if !slices.EqualFunc(got, tt.want, slices.Equal) {
+				t.Errorf("Kind3RelayTags = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFoldKind3(t *testing.T) {
+	contacts := func(id, pubkey string, createdAt nostr.Timestamp, content string) *nostr.Event {
+		return &nostr.Event{ID: id, PubKey: pubkey, Kind: 3, CreatedAt: createdAt, Content: content}
+	}
+	events := []*nostr.Event{
+		// alice's contact list is newer than her relay list and wins.
+		rEvent("a1", "alice", 1, "wss://old.example"),
+		contacts("a2", "alice", 2, `{"wss://new.example": {"read": true, "write": true}}`),
+		// bob's relay list is newer than his contact list and wins.
+		contacts("b1", "bob", 1, `{"wss://old.example": {"read": true, "write": true}}`),
+		rEvent("b2", "bob", 2, "wss://new.example"),
+		// Contact lists without relays or with broken content are dropped.
+		contacts("c1", "carol", 1, ""),
+		contacts("d1", "dave", 1, "{"),
+	}
+	var filtered []string
+	folded := FoldKind3(events, func(ev *nostr.Event) { filtered = append(filtered, ev.ID) })
+
+	if len(folded) != 4 {
+		t.Fatalf("got %d events, want 4", len(folded))
+	}
+	for _, ev := range folded {
+		if ev.Kind != 10002 {
+			t.Errorf("event %s has kind %d, want 10002", ev.ID, ev.Kind)
+		}
+	}
+	if !slices.Equal(filtered, []string{"a2", "b1"}) {
+		t.Errorf("filter saw %v, want only the converted events", filtered)
+	}
+	want := map[string]int{"wss://new.example": 2}
+	if got := TallyRelays(folded); !maps.Equal(got, want) {
+		t.Errorf("TallyRelays = %v, want %v", got, want)
+	}
+}
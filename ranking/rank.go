@@ -0,0 +1,19 @@
+// Package ranking collects NIP-65 relay lists (kind 10002) from relays and
+// ranks relays by the number of users listing them.
+package ranking
+
+import "time"
+
+// Rank is a relay in the ranking.
+type Rank struct {
+	Name        string
+	Count       int
+	Score       float64
+	Description string
+	Markers     MarkerCount
+	Profile     string
+	Software    string
+	Version     string
+	Online      bool          // answered a connection attempt during this run
+	Latency     time.Duration // median connect time, 0 if unknown
+}
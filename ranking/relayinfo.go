@@ -0,0 +1,166 @@
+package ranking
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// RelayInfo is the part of a NIP-11 relay information document used for
+// the ranking.
+type RelayInfo struct {
+	Name           string   `json:"name"`
+	Description    string   `json:"description"`
+	Pubkey         string   `json:"pubkey"`
+	Contact        string   `json:"contact"`
+	RelayCountries []string `json:"relay_countries"`
+	LanguageTags   []string `json:"language_tags"`
+	SupportedNips  NIPList  `json:"supported_nips"`
+	Software       string   `json:"software"`
+	Version        string   `json:"version"`
+	Limitation     struct {
+		AuthRequired     bool `json:"auth_required"`
+		PaymentRequired  bool `json:"payment_required"`
+		MaxSubscriptions int  `json:"max_subscriptions"`
+	} `json:"limitation"`
+}
+
+// NIPList decodes supported_nips. Some relays list NIPs as strings ("01")
+// instead of numbers; those are accepted and anything else is dropped so
+// that one odd entry does not lose the whole document.
+type NIPList []int
+
+func (l *NIPList) UnmarshalJSON(b []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil
+	}
+	*l = (*l)[:0]
+	for _, r := range raw {
+		var n int
+		if json.Unmarshal(r, &n) == nil {
+			*l = append(*l, n)
+			continue
+		}
+		var s string
+		if json.Unmarshal(r, &s) == nil {
+			if n, err := strconv.Atoi(s); err == nil {
+				*l = append(*l, n)
+			}
+		}
+	}
+	return nil
+}
+
+// InfoTimeout limits a single NIP-11 request or Probe.
+const InfoTimeout = 5 * time.Second
+
+// Probe opens up to samples WebSocket connections to relayURL, each within
+// InfoTimeout. It reports whether any succeeded and the median time taken
+// by the successful ones.
+func Probe(relayURL string, samples int) (online bool, latency time.Duration) {
+	var times []time.Duration
+	for range max(samples, 1) {
+		start := time.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), InfoTimeout)
+		relay, err := nostr.RelayConnect(ctx, relayURL)
+		cancel()
+		if err != nil {
+			continue
+		}
+		times = append(times, time.Since(start))
+		relay.Close()
+	}
+	if len(times) == 0 {
+		return false, 0
+	}
+	slices.Sort(times)
+	return true, times[len(times)/2]
+}
+
+// Empty reports whether i carries none of the fields identifying a relay,
+// as is the case when fetching it failed.
+func (i RelayInfo) Empty() bool {
+	return i.Name == "" && i.Description == "" && i.Pubkey == "" && i.Contact == ""
+}
+
+// FetchRelayInfo fetches the NIP-11 document of relayURL, trying up to
+// attempts times with exponential backoff on network errors and 5xx
+// responses. Any failure gives an empty RelayInfo.
+func FetchRelayInfo(relayURL string, attempts int) RelayInfo {
+	backoff := 500 * time.Millisecond
+	for i := 1; ; i++ {
+		info, retry, err := fetchRelayInfoOnce(relayURL)
+		if err == nil {
+			return info
+		}
+		if !retry || i >= attempts {
+			log.Printf("NIP-11 %s: %v", relayURL, err)
+			return RelayInfo{}
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// infoURL returns the HTTP URL the NIP-11 document of relayURL is served at:
+// ws becomes http and wss becomes https, host, port and path are kept.
+func infoURL(relayURL string) (string, error) {
+	u, err := url.Parse(relayURL)
+	if err != nil {
+		return "", err
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	default:
+		return "", fmt.Errorf("%s: not a websocket URL", relayURL)
+	}
+	return u.String(), nil
+}
+
+// infoClient makes the NIP-11 requests.
+var infoClient = &http.Client{Timeout: InfoTimeout}
+
+// fetchRelayInfoOnce makes a single NIP-11 request and reports whether a
+// failure is worth retrying.
+func fetchRelayInfoOnce(relayURL string) (info RelayInfo, retry bool, err error) {
+	httpURL, err := infoURL(relayURL)
+	if err != nil {
+		return RelayInfo{}, false, err
+	}
+
+	req, err := http.NewRequest("GET", httpURL, nil)
+	if err != nil {
+		return info, false, err
+	}
+	req.Header.Set("Accept", "application/nostr+json")
+
+	resp, err := infoClient.Do(req)
+	if err != nil {
+		return info, true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return info, true, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if resp.StatusCode >= 400 {
+		return info, false, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return RelayInfo{}, false, err
+	}
+	return info, false, nil
+}
@@ -0,0 +1,134 @@
+package ranking
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// infoServer serves a NIP-11 document, letting fail decide per request
+// (counted from 1) whether to answer with status instead.
+func infoServer(t *testing.T, fail func(n int32, w http.ResponseWriter) bool) (string, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+		if r.Header.Get("Accept") != "application/nostr+json" {
+			http.Error(w, "not a NIP-11 request", http.StatusNotAcceptable)
+			return
+		}
+		if fail(n, w) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/nostr+json")
+		w.Write([]byte(`{"name": "test", "description": "a test relay", "supported_nips": [1, "11"]}`))
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http"), &requests
+}
+
+func TestFetchRelayInfoRetries(t *testing.T) {
+	client := infoClient
+	infoClient = &http.Client{Timeout: 200 * time.Millisecond}
+	t.Cleanup(func() { infoClient = client })
+
+	tests := []struct {
+		name         string
+		fail         func(n int32, w http.ResponseWriter) bool
+		wantOK       bool
+		wantRequests int32
+	}{
+		{
+			name:         "ok",
+			fail:         func(int32, http.ResponseWriter) bool { return false },
+			wantOK:       true,
+			wantRequests: 1,
+		},
+		{
+			name: "flaky",
+			fail: func(n int32, w http.ResponseWriter) bool {
+				if n == 1 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return true
+				}
+				return false
+			},
+			wantOK:       true,
+			wantRequests: 2,
+		},
+		{
+			name: "timeout once",
+			fail: func(n int32, w http.ResponseWriter) bool {
+				if n == 1 {
+					time.Sleep(time.Second)
+				}
+				return false
+			},
+			wantOK:       true,
+			wantRequests: 2,
+		},
+		{
+			name: "always down",
+			fail: func(n int32, w http.ResponseWriter) bool {
+				w.WriteHeader(http.StatusBadGateway)
+				return true
+			},
+			wantRequests: 3,
+		},
+		{
+			name: "not found is not retried",
+			fail: func(n int32, w http.ResponseWriter) bool {
+				w.WriteHeader(http.StatusNotFound)
+				return true
+			},
+			wantRequests: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			relayURL, requests := infoServer(t, tt.fail)
+			info := FetchRelayInfo(relayURL, 3)
+			if ok := info.Description == "a test relay"; ok != tt.wantOK {
+				t.Errorf("got %+v, want the document: %v", info, tt.wantOK)
+			}
+			if tt.wantOK && len(info.SupportedNips) != 2 {
+				t.Errorf("supported_nips = %v, want [1 11]", info.SupportedNips)
+			}
+			if n := requests.Load(); n != tt.wantRequests {
+				t.Errorf("made %d requests, want %d", n, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestInfoURL(t *testing.T) {
+	tests := []struct {
+		in, want string
+		wantErr  bool
+	}{
+		{in: "wss://relay.example", want: "https://relay.example"},
+		{in: "ws://relay.example", want: "http://relay.example"},
+		{in: "WSS://relay.example", want: "https://relay.example"},
+		{in: "ws://wss.example/wss", want: "http://wss.example/wss"},
+		{in: "wss://relay.example:7777/nostr", want: "https://relay.example:7777/nostr"},
+		{in: "ws://127.0.0.1:8080", want: "http://127.0.0.1:8080"},
+		{in: "wss://[2001:db8::1]:7777", want: "https://[2001:db8::1]:7777"},
+		{in: "https://relay.example", wantErr: true},
+		{in: "relay.example", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := infoURL(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("infoURL(%q) = %q, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("infoURL(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+// Package relaytest provides an in-process Nostr relay, in the spirit of
+// net/http/httptest, so that the collection code of package ranking can be
+// exercised end to end without network access.
+//
+// The relay speaks just enough of NIP-01 for FetchEvents: it answers REQ
+// with the stored events matching the filters, newest first and up to the
+// filter limit, followed by EOSE, and stores the events it is sent. It also
+// serves a NIP-11 document.
+package relaytest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Relay is a running in-process relay. URL is its ws:// address.
+type Relay struct {
+	URL  string
+	Info map[string]any // served as the NIP-11 document
+
+	// Duplicates sends every matching event twice within a response, like
+	// some relays do.
+	Duplicates bool
+
+	// Delay holds every REQ back this long before answering it, like a
+	// slow relay.
+	Delay time.Duration
+
+	// Load counts the connections and queries the relay gets. NewRelay
+	// gives every relay its own; relays may share one to be observed
+	// together.
+	Load *Load
+
+	server *httptest.Server
+	closed chan struct{}
+	mu     sync.Mutex
+	events []*nostr.Event
+}
+
+// NewRelay starts a relay storing events. Call Close when done.
+func NewRelay(events ...*nostr.Event) *Relay {
+	r := &Relay{
+		Info:   map[string]any{"name": "relaytest", "supported_nips": []int{1, 11}},
+		Load:   new(Load),
+		events: events,
+		closed: make(chan struct{}),
+	}
+	r.server = httptest.NewServer(http.HandlerFunc(r.serveHTTP))
+	r.URL = "ws" + strings.TrimPrefix(r.server.URL, "http")
+	return r
+}
+
+// Close shuts the relay down and closes all its connections.
+func (r *Relay) Close() {
+	close(r.closed)
+	r.server.CloseClientConnections()
+	r.server.Close()
+}
+
+// Publish stores more events.
+func (r *Relay) Publish(events ...*nostr.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, events...)
+}
+
+func (r *Relay) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Header.Get("Accept") == "application/nostr+json" {
+		w.Header().Set("Content-Type", "application/nostr+json")
+		json.NewEncoder(w).Encode(r.Info)
+		return
+	}
+	conn, err := websocket.Accept(w, req, nil)
+	if err != nil {
+		return
+	}
+	defer conn.CloseNow()
+	conn.SetReadLimit(-1)
+	r.Load.add(&r.Load.conns)
+
+	ctx := req.Context()
+	for {
+		_, msg, err := conn.Read(ctx)
+		if err != nil {
+			return
+		}
+		var reply []nostr.Envelope
+		switch env := nostr.ParseMessage(string(msg)).(type) {
+		case *nostr.ReqEnvelope:
+			if !r.delay(ctx) {
+				return
+			}
+			for _, ev := range r.query(env.Filters) {
+				reply = append(reply, &nostr.EventEnvelope{SubscriptionID: &env.SubscriptionID, Event: *ev})
+				if r.Duplicates {
+					reply = append(reply, &nostr.EventEnvelope{SubscriptionID: &env.SubscriptionID, Event: *ev})
+				}
+			}
+			eose := nostr.EOSEEnvelope(env.SubscriptionID)
+			reply = append(reply, &eose)
+		case *nostr.EventEnvelope:
+			ok := &nostr.OKEnvelope{EventID: env.Event.ID, OK: true}
+			if valid, _ := env.Event.CheckSignature(); valid {
+				r.Publish(&env.Event)
+			} else {
+				ok.OK, ok.Reason = false, "invalid: bad signature"
+			}
+			reply = append(reply, ok)
+		case *nostr.CloseEnvelope:
+		default:
+			notice := nostr.NoticeEnvelope("unsupported message")
+			reply = append(reply, &notice)
+		}
+		if err := write(ctx, conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// delay waits out Delay while counting the REQ as being answered. It
+// returns false when the relay or the connection was closed meanwhile.
+func (r *Relay) delay(ctx context.Context) bool {
+	r.Load.begin()
+	defer r.Load.end()
+	if r.Delay <= 0 {
+		return true
+	}
+	timer := time.NewTimer(r.Delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+	case <-r.closed:
+	}
+	return false
+}
+
+// Load counts what clients did to one or more relays.
+type Load struct {
+	mu     sync.Mutex
+	conns  int
+	reqs   int
+	active int
+	peak   int
+}
+
+func (l *Load) begin() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.reqs++
+	l.active++
+	l.peak = max(l.peak, l.active)
+}
+
+func (l *Load) end() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active--
+}
+
+func (l *Load) add(n *int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	*n++
+}
+
+// Connections returns how many WebSocket connections were accepted.
+func (l *Load) Connections() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.conns
+}
+
+// Queries returns how many REQ messages were received.
+func (l *Load) Queries() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.reqs
+}
+
+// Peak returns the most REQs that were being answered at the same time.
+// A client waiting for EOSE before moving on is therefore counted until it
+// gets it.
+func (l *Load) Peak() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.peak
+}
+
+func write(ctx context.Context, conn *websocket.Conn, envs []nostr.Envelope) error {
+	for _, env := range envs {
+		b, err := env.MarshalJSON()
+		if err != nil {
+			return err
+		}
+		if err := conn.Write(ctx, websocket.MessageText, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// query returns the stored events matching any of filters, newest first,
+// with at most Limit events per filter when it is set.
+func (r *Relay) query(filters nostr.Filters) []*nostr.Event {
+	r.mu.Lock()
+	events := slices.Clone(r.events)
+	r.mu.Unlock()
+	slices.SortStableFunc(events, func(a, b *nostr.Event) int { return int(b.CreatedAt - a.CreatedAt) })
+
+	var matched []*nostr.Event
+	seen := make(map[string]bool)
+	for _, f := range filters {
+		n := 0
+		for _, ev := range events {
+			if f.Limit > 0 && n >= f.Limit {
+				break
+			}
+			if !f.Matches(ev) {
+				continue
+			}
+			n++
+			if !seen[ev.ID] {
+				seen[ev.ID] = true
+				matched = append(matched, ev)
+			}
+		}
+	}
+	return matched
+}
+
+// RelayList returns a kind 10002 event created at createdAt listing relays,
+// signed with the hex secret key sk. A relay given as "wss://...#read" or
+// "#write" gets that marker.
+func RelayList(sk string, createdAt nostr.Timestamp, relays ...string) (*nostr.Event, error) {
+	ev := &nostr.Event{Kind: 10002, CreatedAt: createdAt}
+	for _, u := range relays {
+		tag := nostr.Tag{"r", u}
+		if base, marker, ok := strings.Cut(u, "#"); ok {
+			tag = nostr.Tag{"r", base, marker}
+		}
+		ev.Tags = append(ev.Tags, tag)
+	}
+	if err := ev.Sign(sk); err != nil {
+		return nil, err
+	}
+	return ev, nil
+}
@@ -0,0 +1,319 @@
+package ranking
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// dedupKey identifies the replaceable slot an event occupies: pubkey and
+// kind, plus the d tag for addressable kinds.
+func dedupKey(ev *nostr.Event) string {
+	if nostr.IsAddressableKind(ev.Kind) {
+		return fmt.Sprintf("%s:%d:%s", ev.PubKey, ev.Kind, ev.Tags.GetD())
+	}
+	return fmt.Sprintf("%s:%d", ev.PubKey, ev.Kind)
+}
+
+// LatestEvents keeps only the newest event for each dedup key. Events
+// created at the same second are resolved as NIP-01 does for replaceable
+// events, keeping the lowest ID, so the result does not depend on the order
+// relays answered in.
+func LatestEvents(events []*nostr.Event) map[string]*nostr.Event {
+	seen := make(map[string]*nostr.Event)
+	for _, ev := range events {
+		key := dedupKey(ev)
+		old, ok := seen[key]
+		if !ok || old.CreatedAt < ev.CreatedAt || (old.CreatedAt == ev.CreatedAt && ev.ID < old.ID) {
+			seen[key] = ev
+		}
+	}
+	return seen
+}
+
+// NormalizeRelayURL returns the form relays are counted under, so that
+// wss://Relay.Example:443/ and wss://relay.example are the same relay: the
+// scheme and host are lowercased, default ports and trailing slashes are
+// removed. Unparsable URLs are only trimmed.
+//
+// ws:// and wss:// are deliberately kept apart rather than collapsed: the
+// result is also used to connect and publish, where the scheme decides
+// whether TLS is used, and the command line tool drops ws:// r tags before
+// tallying (filterRelayTags), so insecure variants never split a count.
+func NormalizeRelayURL(raw string) string {
+	raw = strings.TrimRight(strings.TrimSpace(raw), "/")
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return raw
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	switch {
+	case u.Scheme == "wss" && u.Port() == "443", u.Scheme == "ws" && u.Port() == "80":
+		// Hostname drops the brackets of an IPv6 literal, which the URL
+		// needs back.
+		u.Host = u.Hostname()
+		if strings.Contains(u.Host, ":") {
+			u.Host = "[" + u.Host + "]"
+		}
+	}
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = ""
+	u.Fragment = ""
+	return u.String()
+}
+
+// EventRelays returns the distinct relay URLs listed in the r-tags of ev.
+func EventRelays(ev *nostr.Event) []string {
+	var urls []string
+	for _, tag := range ev.Tags {
+		if len(tag) >= 2 && tag[0] == "r" {
+			url := NormalizeRelayURL(tag[1])
+			if strings.HasPrefix(url, "ws") && !slices.Contains(urls, url) {
+				urls = append(urls, url)
+			}
+		}
+	}
+	return urls
+}
+
+// ProxyProtocols are the protocols NIP-48 proxy tags name.
+var ProxyProtocols = []string{"activitypub", "atproto", "rss", "web"}
+
+// ProxyProtocol returns the protocol ev was proxied from according to its
+// NIP-48 proxy tag, such as "activitypub", or "" for a native event.
+func ProxyProtocol(ev *nostr.Event) string {
+	for _, tag := range ev.Tags {
+		if len(tag) == 0 || tag[0] != "proxy" {
+			continue
+		}
+		if len(tag) < 3 {
+			log.Printf("skipping malformed proxy tag %q in event %s", tag, ev.ID)
+			continue
+		}
+		return strings.ToLower(tag[2])
+	}
+	return ""
+}
+
+// IsBridged reports whether ev was proxied from another network such as
+// ActivityPub (NIP-48 proxy tag).
+func IsBridged(ev *nostr.Event) bool {
+	return ProxyProtocol(ev) != ""
+}
+
+// SplitBridged separates the events proxied from one of protocols from the
+// others.
+func SplitBridged(events []*nostr.Event, protocols []string) (native, bridged []*nostr.Event) {
+	for _, ev := range events {
+		if p := ProxyProtocol(ev); p != "" && slices.Contains(protocols, p) {
+			bridged = append(bridged, ev)
+		} else {
+			native = append(native, ev)
+		}
+	}
+	return native, bridged
+}
+
+// TallyRelays counts, for each relay, the users whose newest relay list
+// mentions it. It does no I/O, so the same events always give the same counts.
+func TallyRelays(events []*nostr.Event) map[string]int {
+	result := make(map[string]int)
+	for _, ev := range LatestEvents(events) {
+		for _, url := range EventRelays(ev) {
+			result[url]++
+		}
+	}
+	return result
+}
+
+// TallyWeighted gives every user one vote in total, split evenly across
+// the distinct relays they list.
+func TallyWeighted(events []*nostr.Event) map[string]float64 {
+	result := make(map[string]float64)
+	for _, ev := range LatestEvents(events) {
+		urls := EventRelays(ev)
+		for _, url := range urls {
+			result[url] += 1 / float64(len(urls))
+		}
+	}
+	return result
+}
+
+// TallyDecayed weighs every user's vote by how recently their relay list
+// was updated: a list halfLife old counts half, one twice as old a quarter,
+// and so on. Lists dated after now count fully.
+func TallyDecayed(events []*nostr.Event, halfLife time.Duration, now time.Time) map[string]float64 {
+	result := make(map[string]float64)
+	for _, ev := range LatestEvents(events) {
+		age := max(now.Sub(ev.CreatedAt.Time()), 0)
+		w := math.Exp(-math.Ln2 * age.Seconds() / halfLife.Seconds())
+		for _, url := range EventRelays(ev) {
+			result[url] += w
+		}
+	}
+	return result
+}
+
+// UniqueUsers returns how many distinct pubkeys published a relay list.
+func UniqueUsers(events []*nostr.Event) int {
+	users := make(map[string]struct{})
+	for _, ev := range events {
+		users[ev.PubKey] = struct{}{}
+	}
+	return len(users)
+}
+
+// UserRelayBuckets groups users by how many distinct relays they list.
+var UserRelayBuckets = []struct {
+	Label    string
+	Min, Max int
+}{
+	{"1", 1, 1},
+	{"2-3", 2, 3},
+	{"4-6", 4, 6},
+	{"7-10", 7, 10},
+	{"11+", 11, int(^uint(0) >> 1)},
+}
+
+// ListSizeStats summarizes how many distinct relays users list.
+type ListSizeStats struct {
+	Min, Max     int
+	Median, Mean float64
+}
+
+// RelayListSizes returns the ListSizeStats of the users listing at least
+// one relay, or the zero value when there are none.
+func RelayListSizes(events []*nostr.Event) ListSizeStats {
+	var sizes []int
+	total := 0
+	for _, ev := range LatestEvents(events) {
+		if n := len(EventRelays(ev)); n > 0 {
+			sizes = append(sizes, n)
+			total += n
+		}
+	}
+	if len(sizes) == 0 {
+		return ListSizeStats{}
+	}
+	slices.Sort(sizes)
+	n := len(sizes)
+	median := float64(sizes[n/2])
+	if n%2 == 0 {
+		median = float64(sizes[n/2-1]+sizes[n/2]) / 2
+	}
+	return ListSizeStats{Min: sizes[0], Max: sizes[n-1], Median: median, Mean: float64(total) / float64(n)}
+}
+
+// RelaysPerUser counts users per UserRelayBuckets entry. Users listing no
+// relay at all are not counted.
+func RelaysPerUser(events []*nostr.Event) []int {
+	histogram := make([]int, len(UserRelayBuckets))
+	for _, ev := range LatestEvents(events) {
+		n := len(EventRelays(ev))
+		for i, b := range UserRelayBuckets {
+			if n >= b.Min && n <= b.Max {
+				histogram[i]++
+				break
+			}
+		}
+	}
+	return histogram
+}
+
+// MarkerCount counts how a relay is cited in r tags: read only, write only,
+// or without marker, which means both.
+type MarkerCount struct {
+	Read, Write, Both int
+}
+
+// TallyMarkers counts the NIP-65 markers each relay is cited with. A relay
+// listed several times in one event counts once for that user: as read or
+// write only if every tag for it says so, as both otherwise.
+func TallyMarkers(events []*nostr.Event) map[string]MarkerCount {
+	result := make(map[string]MarkerCount)
+	for _, ev := range LatestEvents(events) {
+		type usage struct{ read, write bool }
+		uses := make(map[string]usage)
+		for _, tag := range ev.Tags {
+			if len(tag) < 2 || tag[0] != "r" {
+				continue
+			}
+			url := NormalizeRelayURL(tag[1])
+			if !strings.HasPrefix(url, "ws") {
+				continue
+			}
+			u := uses[url]
+			switch {
+			case len(tag) >= 3 && tag[2] == "read":
+				u.read = true
+			case len(tag) >= 3 && tag[2] == "write":
+				u.write = true
+			default:
+				u.read, u.write = true, true
+			}
+			uses[url] = u
+		}
+		for url, u := range uses {
+			mc := result[url]
+			switch {
+			case u.read && u.write:
+				mc.Both++
+			case u.read:
+				mc.Read++
+			default:
+				mc.Write++
+			}
+			result[url] = mc
+		}
+	}
+	return result
+}
+
+// Reads is the number of users reading from the relay. Unmarked r tags
+// count as both read and write (NIP-65).
+func (mc MarkerCount) Reads() int { return mc.Read + mc.Both }
+
+// Writes is the number of users writing to the relay.
+func (mc MarkerCount) Writes() int { return mc.Write + mc.Both }
+
+// ModeCounts turns the marker tally into per-relay counts for mode "read" or
+// write. Relays nobody uses that way are left out.
+func ModeCounts(markers map[string]MarkerCount, mode string) map[string]int {
+	result := make(map[string]int)
+	for url, mc := range markers {
+		n := mc.Reads()
+		if mode == "write" {
+			n = mc.Writes()
+		}
+		if n > 0 {
+			result[url] = n
+		}
+	}
+	return result
+}
+
+// ProfileThreshold is the share of read only (or write only) citations
+// above which a relay is considered mostly read (or mostly write).
+const ProfileThreshold = 0.6
+
+// Profile classifies a relay as "read", "write" or "mixed".
+func (mc MarkerCount) Profile() string {
+	total := mc.Read + mc.Write + mc.Both
+	if total == 0 {
+		return ""
+	}
+	switch {
+	case float64(mc.Read)/float64(total) >= ProfileThreshold:
+		return "read"
+	case float64(mc.Write)/float64(total) >= ProfileThreshold:
+		return "write"
+	}
+	return "mixed"
+}
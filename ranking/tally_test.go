@@ -0,0 +1,422 @@
+package ranking
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// rEvent returns a kind 10002 event of pubkey with an r tag per relay.
+func rEvent(id, pubkey string, createdAt nostr.Timestamp, relays ...string) *nostr.Event {
+	ev := &nostr.Event{ID: id, PubKey: pubkey, Kind: 10002, CreatedAt: createdAt}
+	for _, r := range relays {
+		ev.Tags = append(ev.Tags, nostr.Tag{"r", r})
+	}
+	return ev
+}
+
+func TestTallyWeighted(t *testing.T) {
+	events := []*nostr.Event{
+		rEvent("1", "alice", 1, "wss://a.example"),
+		rEvent("2", "bob", 1, "wss://a.example", "wss://b.example", "wss://c.example", "wss://d.example"),
+		// Listed twice, so carol's vote is split in two, not three.
+		rEvent("3", "carol", 1, "wss://a.example", "wss://b.example", "wss://B.example/"),
+	}
+	equal := TallyRelays(events)
+	weighted := TallyWeighted(events)
+
+	if equal["wss://a.example"] != 3 {
+		t.Errorf("equal a = %d, want 3", equal["wss://a.example"])
+	}
+	want := map[string]float64{
+		"wss://a.example": 1 + 0.25 + 0.5,
+		"wss://b.example": 0.25 + 0.5,
+		"wss://c.example": 0.25,
+		"wss://d.example": 0.25,
+	}
+	for url, w := range want {
+		if math.Abs(weighted[url]-w) > 1e-9 {
+			t.Errorf("weighted %s = %v, want %v", url, weighted[url], w)
+		}
+	}
+	if len(weighted) != len(want) {
+		t.Errorf("weighted has %d relays, want %d", len(weighted), len(want))
+	}
+
+	// Every user distributes exactly one vote.
+	for _, ev := range events {
+		sum := 0.0
+		for _, w := range TallyWeighted([]*nostr.Event{ev}) {
+			sum += w
+		}
+		if math.Abs(sum-1) > 1e-9 {
+			t.Errorf("weights of %s sum to %v, want 1", ev.PubKey, sum)
+		}
+	}
+}
+
+// relays returns n distinct relay URLs.
+func relays(n int) []string {
+	urls := make([]string, n)
+	for i := range urls {
+		urls[i] = fmt.Sprintf("wss://r%d.example", i)
+	}
+	return urls
+}
+
+func TestRelaysPerUser(t *testing.T) {
+	// One user per list size at the edges of UserRelayBuckets.
+	var events []*nostr.Event
+	for _, n := range []int{0, 1, 2, 3, 4, 6, 7, 10, 11, 30} {
+		pubkey := fmt.Sprintf("user%d", n)
+		events = append(events, rEvent(pubkey, pubkey, 1, relays(n)...))
+	}
+	// Duplicates count once: this user lists a single relay.
+	events = append(events, rEvent("dup", "dup", 1, "wss://r0.example", "wss://R0.example/"))
+
+	got := RelaysPerUser(events)
+	want := []int{2, 2, 2, 2, 2} // 1, 2-3, 4-6, 7-10, 11+
+	if !slices.Equal(got, want) {
+		t.Errorf("RelaysPerUser = %v, want %v", got, want)
+	}
+}
+
+func TestProxyProtocolMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		tags nostr.Tags
+		want string
+	}{
+		{"none", nostr.Tags{{"r", "wss://a.example"}}, ""},
+		{"activitypub", nostr.Tags{{"proxy", "https://example.com/u/1", "activitypub"}}, "activitypub"},
+		{"upper case", nostr.Tags{{"proxy", "https://example.com/u/1", "ActivityPub"}}, "activitypub"},
+		{"no protocol", nostr.Tags{{"proxy", "foo"}}, ""},
+		{"bare", nostr.Tags{{"proxy"}}, ""},
+		{"empty tag", nostr.Tags{{}}, ""},
+		{"malformed then valid", nostr.Tags{{"proxy", "foo"}, {"proxy", "at://did:plc:x", "atproto"}}, "atproto"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev := &nostr.Event{ID: "1", Kind: 10002, Tags: tt.tags}
+			if got := ProxyProtocol(ev); got != tt.want {
+				t.Errorf("ProxyProtocol(%v) = %q, want %q", tt.tags, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTallyRelays(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []*nostr.Event
+		want   map[string]int
+	}{
+		{
+			name:   "empty",
+			events: nil,
+			want:   map[string]int{},
+		},
+		{
+			name: "one vote per user",
+			events: []*nostr.Event{
+				rEvent("1", "alice", 1, "wss://a.example", "wss://b.example"),
+				rEvent("2", "bob", 1, "wss://a.example"),
+			},
+			want: map[string]int{"wss://a.example": 2, "wss://b.example": 1},
+		},
+		{
+			name: "same event from two relays",
+			events: []*nostr.Event{
+				rEvent("1", "alice", 1, "wss://a.example"),
+				rEvent("1", "alice", 1, "wss://a.example"),
+			},
+			want: map[string]int{"wss://a.example": 1},
+		},
+		{
+			name: "replaced by a newer list",
+			events: []*nostr.Event{
+				rEvent("2", "alice", 2, "wss://b.example"),
+				rEvent("1", "alice", 1, "wss://a.example"),
+				rEvent("3", "alice", 3, "wss://c.example"),
+			},
+			want: map[string]int{"wss://c.example": 1},
+		},
+		{
+			name: "same second keeps the lowest id",
+			events: []*nostr.Event{
+				rEvent("bb", "alice", 5, "wss://b.example"),
+				rEvent("aa", "alice", 5, "wss://a.example"),
+				rEvent("cc", "alice", 5, "wss://c.example"),
+			},
+			want: map[string]int{"wss://a.example": 1},
+		},
+		{
+			name: "url variants",
+			events: []*nostr.Event{
+				rEvent("1", "alice", 1, "wss://relay.example"),
+				rEvent("2", "bob", 1, "wss://relay.example/"),
+				rEvent("3", "carol", 1, "wss://Relay.Example"),
+				rEvent("4", "dave", 1, " wss://relay.example:443 "),
+				rEvent("5", "erin", 1, "WSS://RELAY.EXAMPLE/"),
+			},
+			want: map[string]int{"wss://relay.example": 5},
+		},
+		{
+			name: "non-ws r tags",
+			events: []*nostr.Event{
+				rEvent("1", "alice", 1, "https://relay.example", "relay.example", "", "wss://a.example"),
+				{ID: "2", PubKey: "bob", Kind: 10002, Tags: nostr.Tags{{"r"}, {"p", "wss://b.example"}}},
+			},
+			want: map[string]int{"wss://a.example": 1},
+		},
+		{
+			name: "kinds do not replace each other",
+			events: []*nostr.Event{
+				rEvent("1", "alice", 1, "wss://a.example"),
+				{ID: "2", PubKey: "alice", Kind: 10050, CreatedAt: 2, Tags: nostr.Tags{{"r", "wss://dm.example"}}},
+			},
+			want: map[string]int{"wss://a.example": 1, "wss://dm.example": 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := TallyRelays(tt.events)
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("TallyRelays = %v, want %v", got, tt.want)
+			}
+			// The order relays answered in must not matter.
+			reversed := slices.Clone(tt.events)
+			slices.Reverse(reversed)
+			if got := TallyRelays(reversed); !maps.Equal(got, tt.want) {
+				t.Errorf("TallyRelays of the reversed events = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeRelayURL(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"wss://relay.example", "wss://relay.example"},
+		{"wss://relay.example/", "wss://relay.example"},
+		{"wss://relay.example//", "wss://relay.example"},
+		{"wss://Relay.Example", "wss://relay.example"},
+		{"WSS://relay.example", "wss://relay.example"},
+		{"  wss://relay.example  ", "wss://relay.example"},
+		{"wss://relay.example:443", "wss://relay.example"},
+		{"ws://relay.example:80/", "ws://relay.example"},
+		{"ws://relay.example:443", "ws://relay.example:443"},
+		// The scheme is normalized but not collapsed, see NormalizeRelayURL.
+		{"WS://Relay.Example/", "ws://relay.example"},
+		{"ws://relay.example", "ws://relay.example"},
+		{"wss://relay.example:7777/", "wss://relay.example:7777"},
+		{"wss://relay.example/Path/", "wss://relay.example/Path"},
+		{"wss://relay.example#frag", "wss://relay.example"},
+		{"wss://[2001:db8::1]:443", "wss://[2001:db8::1]"},
+		{"wss://[2001:DB8::1]:443/", "wss://[2001:db8::1]"},
+		{"ws://[2001:db8::1]:80", "ws://[2001:db8::1]"},
+		{"wss://[2001:db8::1]:7777", "wss://[2001:db8::1]:7777"},
+		{"wss://[::1]", "wss://[::1]"},
+		{"relay.example/", "relay.example"},
+		{"%zz", "%zz"},
+	}
+	for _, tt := range tests {
+		if got := NormalizeRelayURL(tt.in); got != tt.want {
+			t.Errorf("NormalizeRelayURL(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		// Normalizing is idempotent.
+		if got := NormalizeRelayURL(tt.want); got != tt.want {
+			t.Errorf("NormalizeRelayURL(%q) = %q, want it unchanged", tt.want, got)
+		}
+	}
+}
+
+func TestDuplicateRelayTags(t *testing.T) {
+	ev := &nostr.Event{ID: "1", PubKey: "alice", Kind: 10002, Tags: nostr.Tags{
+		{"r", "wss://relay.example", "read"},
+		{"r", "wss://Relay.Example/", "write"},
+		{"r", "wss://relay.example"},
+		{"r", "WSS://RELAY.EXAMPLE:443"},
+	}}
+	events := []*nostr.Event{ev}
+
+	if got := TallyRelays(events)["wss://relay.example"]; got != 1 {
+		t.Errorf("TallyRelays counted the relay %d times, want 1", got)
+	}
+	if got := EventRelays(ev); !slices.Equal(got, []string{"wss://relay.example"}) {
+		t.Errorf("EventRelays = %v, want the relay once", got)
+	}
+	// Read plus write is both, still for one user.
+	if got := TallyMarkers(events)["wss://relay.example"]; got != (MarkerCount{Both: 1}) {
+		t.Errorf("TallyMarkers = %+v, want one user reading and writing", got)
+	}
+}
+
+func TestLatestEventsSameSecond(t *testing.T) {
+	// Relays answer in any order; every order must keep the same event.
+	events := []*nostr.Event{
+		rEvent("b0", "alice", 5, "wss://b.example"),
+		rEvent("a9", "alice", 5, "wss://a.example"),
+		rEvent("c1", "alice", 5, "wss://c.example"),
+		rEvent("00", "alice", 4, "wss://old.example"),
+	}
+	orders := [][]int{{0, 1, 2, 3}, {0, 2, 1, 3}, {1, 0, 2, 3}, {1, 2, 0, 3}, {2, 0, 1, 3}, {2, 1, 0, 3}, {3, 2, 1, 0}}
+	for _, order := range orders {
+		var in []*nostr.Event
+		for _, i := range order {
+			in = append(in, events[i])
+		}
+		latest := LatestEvents(in)
+		if len(latest) != 1 {
+			t.Fatalf("order %v: got %d events, want 1 per user", order, len(latest))
+		}
+		for _, ev := range latest {
+			if ev.ID != "a9" {
+				t.Errorf("order %v: kept %s, want a9, the lowest id of the newest second", order, ev.ID)
+			}
+		}
+	}
+}
+
+func TestSplitBridged(t *testing.T) {
+	native := rEvent("native", "alice", 1, "wss://a.example")
+	for _, protocol := range ProxyProtocols {
+		t.Run(protocol, func(t *testing.T) {
+			proxied := rEvent(protocol, "bob", 1, "wss://a.example")
+			proxied.Tags = append(proxied.Tags, nostr.Tag{"proxy", "https://example.com/" + protocol, protocol})
+			if got := ProxyProtocol(proxied); got != protocol {
+				t.Errorf("ProxyProtocol = %q, want %q", got, protocol)
+			}
+			events := []*nostr.Event{native, proxied}
+
+			gotNative, gotBridged := SplitBridged(events, []string{protocol})
+			if !slices.Equal(gotNative, []*nostr.Event{native}) || !slices.Equal(gotBridged, []*nostr.Event{proxied}) {
+				t.Errorf("excluding %s: native %d, bridged %d events, want 1 and 1", protocol, len(gotNative), len(gotBridged))
+			}
+			// Events of the other protocols stay native.
+			others := slices.DeleteFunc(slices.Clone(ProxyProtocols), func(p string) bool { return p == protocol })
+			gotNative, gotBridged = SplitBridged(events, others)
+			if len(gotNative) != 2 || len(gotBridged) != 0 {
+				t.Errorf("excluding %v: native %d, bridged %d events, want 2 and 0", others, len(gotNative), len(gotBridged))
+			}
+		})
+	}
+}
+
+func TestTallyDecayed(t *testing.T) {
+	halfLife := 30 * 24 * time.Hour
+	now := time.Unix(1700000000, 0)
+	at := func(d time.Duration) nostr.Timestamp { return nostr.Timestamp(now.Add(d).Unix()) }
+
+	tests := []struct {
+		name string
+		age  time.Duration
+		want float64
+	}{
+		{"now", 0, 1},
+		{"one half-life", halfLife, 0.5},
+		{"two half-lives", 2 * halfLife, 0.25},
+		{"in the future", -time.Hour, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The relay is listed twice and still gets the weight once.
+			events := []*nostr.Event{rEvent("1", "alice", at(-tt.age), "wss://a.example", "wss://A.example/")}
+			got := TallyDecayed(events, halfLife, now)
+			if math.Abs(got["wss://a.example"]-tt.want) > 1e-9 || len(got) != 1 {
+				t.Errorf("TallyDecayed = %v, want wss://a.example at %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRelayListSizes(t *testing.T) {
+	users := func(sizes ...int) []*nostr.Event {
+		var events []*nostr.Event
+		for i, n := range sizes {
+			pubkey := fmt.Sprintf("user%d", i)
+			events = append(events, rEvent(pubkey, pubkey, 1, relays(n)...))
+		}
+		return events
+	}
+	tests := []struct {
+		name   string
+		events []*nostr.Event
+		want   ListSizeStats
+	}{
+		{"none", nil, ListSizeStats{}},
+		{"only empty lists", users(0, 0), ListSizeStats{}},
+		{"odd count", users(5, 1, 3), ListSizeStats{Min: 1, Max: 5, Median: 3, Mean: 3}},
+		{"even count", users(4, 1, 2, 9), ListSizeStats{Min: 1, Max: 9, Median: 3, Mean: 4}},
+		{"empty lists left out", users(2, 0, 4), ListSizeStats{Min: 2, Max: 4, Median: 3, Mean: 3}},
+		{
+			name: "duplicates count once",
+			events: []*nostr.Event{
+				rEvent("1", "alice", 1, "wss://a.example", "wss://A.example/", "wss://b.example"),
+				rEvent("2", "bob", 1, "wss://a.example"),
+			},
+			want: ListSizeStats{Min: 1, Max: 2, Median: 1.5, Mean: 1.5},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RelayListSizes(tt.events); got != tt.want {
+				t.Errorf("RelayListSizes = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLatestEventsDedupKey(t *testing.T) {
+	set := func(id string, createdAt nostr.Timestamp, d string) *nostr.Event {
+		return &nostr.Event{ID: id, PubKey: "alice", Kind: 30002, CreatedAt: createdAt, Tags: nostr.Tags{{"d", d}, {"relay", "wss://a.example"}}}
+	}
+	tests := []struct {
+		name   string
+		events []*nostr.Event
+		want   []string // ids kept
+	}{
+		{
+			name:   "addressable events with different d tags",
+			events: []*nostr.Event{set("1", 1, "home"), set("2", 2, "work")},
+			want:   []string{"1", "2"},
+		},
+		{
+			name:   "addressable events with the same d tag",
+			events: []*nostr.Event{set("1", 1, "home"), set("2", 2, "home"), set("3", 3, "work")},
+			want:   []string{"2", "3"},
+		},
+		{
+			name: "replaceable events of different kinds",
+			events: []*nostr.Event{
+				rEvent("1", "alice", 1, "wss://a.example"),
+				{ID: "2", PubKey: "alice", Kind: 10050, CreatedAt: 2},
+				set("3", 3, "home"),
+			},
+			want: []string{"1", "2", "3"},
+		},
+		{
+			name:   "replaceable events of the same kind",
+			events: []*nostr.Event{rEvent("1", "alice", 1), rEvent("2", "alice", 2), rEvent("3", "bob", 1)},
+			want:   []string{"2", "3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, ev := range LatestEvents(tt.events) {
+				got = append(got, ev.ID)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("LatestEvents kept %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
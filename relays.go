@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattn/nostr-relay-ranking/ranking"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// relayEntry is an element of a JSON relay list, either a URL or an object
+// with the URL and the number of events to collect from that relay.
+type relayEntry struct {
+	URL   string `json:"url"`
+	Limit int    `json:"limit"`
+}
+
+func (e *relayEntry) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, &e.URL); err == nil {
+		return nil
+	}
+	type plain relayEntry
+	return json.Unmarshal(b, (*plain)(e))
+}
+
+// parseRelayList accepts either a JSON array or one relay per line. Blank
+// lines and lines starting with # are ignored. A relay may carry a limit
+// overriding -max-events for it, as {"url": ..., "limit": 20000} in JSON or
+// as a second field on its line. The limits are returned keyed by URL.
+func parseRelayList(b []byte) ([]string, map[string]int, error) {
+	var relays []string
+	limits := make(map[string]int)
+	add := func(u string, limit int) error {
+		u = strings.TrimSpace(u)
+		if limit < 0 {
+			return fmt.Errorf("%s: negative limit %d", u, limit)
+		}
+		if limit > 0 {
+			limits[u] = limit
+		}
+		relays = append(relays, u)
+		return nil
+	}
+
+	b = bytes.TrimSpace(b)
+	if bytes.HasPrefix(b, []byte("[")) {
+		var entries []relayEntry
+		if err := json.Unmarshal(b, &entries); err != nil {
+			return nil, nil, err
+		}
+		for _, e := range entries {
+			if err := add(e.URL, e.Limit); err != nil {
+				return nil, nil, err
+			}
+		}
+		return relays, limits, nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		limit := 0
+		if len(fields) > 1 {
+			n, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: invalid limit %q", fields[0], fields[1])
+			}
+			limit = n
+		}
+		if err := add(fields[0], limit); err != nil {
+			return nil, nil, err
+		}
+	}
+	return relays, limits, scanner.Err()
+}
+
+// readStdin reads standard input once, so that -serve can reload a relay
+// list piped in with -relays - on every refresh.
+var readStdin = sync.OnceValues(func() ([]byte, error) {
+	return io.ReadAll(os.Stdin)
+})
+
+// loadRelaysFile reads the seed relay list from a local file, or standard
+// input when path is "-", in the same formats as parseRelayList.
+func loadRelaysFile(path string) ([]string, map[string]int, error) {
+	var b []byte
+	var err error
+	if path == "-" {
+		path = "stdin"
+		b, err = readStdin()
+	} else {
+		b, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	relays, limits, err := parseRelayList(b)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	log.Printf("✨ %s から %d 件のリレーを読み込みました", path, len(relays))
+	return relays, limits, nil
+}
+
+// validRelays drops entries that are not ws:// or wss:// URLs, logging each
+// one, so a single typo in a relay list does not abort the run.
+func validRelays(relays []string) []string {
+	valid := make([]string, 0, len(relays))
+	for _, r := range relays {
+		r = strings.TrimSpace(r)
+		u, err := url.Parse(r)
+		if err != nil || (u.Scheme != "ws" && u.Scheme != "wss") || u.Host == "" {
+			log.Printf("skipping invalid relay URL %q", r)
+			continue
+		}
+		valid = append(valid, r)
+	}
+	return valid
+}
+
+// loadRelaysURL fetches the seed relay list from rurl and keeps a copy in
+// cachePath. When the fetch fails the cached copy is used instead.
+func loadRelaysURL(rurl, cachePath string) ([]string, map[string]int, error) {
+	relays, limits, err := fetchRelayList(rurl, cachePath)
+	if err == nil {
+		log.Printf("✨ %s から %d 件のリレーを読み込みました", rurl, len(relays))
+		return relays, limits, nil
+	}
+	log.Printf("fetch relay list %s: %v", rurl, err)
+
+	if cachePath == "" {
+		return nil, nil, err
+	}
+	b, cerr := os.ReadFile(cachePath)
+	if cerr != nil {
+		return nil, nil, fmt.Errorf("fetch relay list %s: %w (no usable cache: %v)", rurl, err, cerr)
+	}
+	relays, limits, cerr = parseRelayList(b)
+	if cerr != nil || len(relays) == 0 {
+		return nil, nil, fmt.Errorf("fetch relay list %s: %w (no usable cache: %v)", rurl, err, cerr)
+	}
+	log.Printf("✨ キャッシュ %s から %d 件のリレーを読み込みました", cachePath, len(relays))
+	return relays, limits, nil
+}
+
+func fetchRelayList(rurl, cachePath string) ([]string, map[string]int, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(rurl)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, nil, err
+	}
+	relays, limits, err := parseRelayList(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(relays) == 0 {
+		return nil, nil, fmt.Errorf("empty relay list")
+	}
+
+	if cachePath != "" {
+		if err := os.WriteFile(cachePath, b, 0644); err != nil {
+			log.Printf("write relay list cache %s: %v", cachePath, err)
+		}
+	}
+	return relays, limits, nil
+}
+
+// discoverRelays returns up to n of the relays most listed in events that
+// are not in queried, for a further collection pass. Relays are compared
+// normalized.
+func discoverRelays(events []*nostr.Event, queried []string, n int) []string {
+	known := make(map[string]bool, len(queried))
+	for _, r := range queried {
+		known[ranking.NormalizeRelayURL(r)] = true
+	}
+	type candidate struct {
+		url   string
+		count int
+	}
+	var candidates []candidate
+	for u, count := range ranking.TallyRelays(events) {
+		if !known[u] {
+			candidates = append(candidates, candidate{u, count})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].count != candidates[j].count {
+			return candidates[i].count > candidates[j].count
+		}
+		return candidates[i].url < candidates[j].url
+	})
+	var found []string
+	for _, c := range candidates[:min(n, len(candidates))] {
+		found = append(found, c.url)
+	}
+	return validRelays(found)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestParseRelayList(t *testing.T) {
+	tests := []struct {
+		name       string
+		src        string
+		wantRelays []string
+		wantLimits map[string]int
+		wantErr    bool
+	}{
+		{
+			name:       "json strings",
+			src:        `["wss://a.example", "wss://b.example"]`,
+			wantRelays: []string{"wss://a.example", "wss://b.example"},
+			wantLimits: map[string]int{},
+		},
+		{
+			name:       "json objects",
+			src:        `["wss://a.example", {"url": "wss://b.example", "limit": 20000}]`,
+			wantRelays: []string{"wss://a.example", "wss://b.example"},
+			wantLimits: map[string]int{"wss://b.example": 20000},
+		},
+		{
+			name:       "lines",
+			src:        "# seeds\nwss://a.example\n\n  wss://b.example 500\n",
+			wantRelays: []string{"wss://a.example", "wss://b.example"},
+			wantLimits: map[string]int{"wss://b.example": 500},
+		},
+		{name: "broken json", src: `["wss://a.example",`, wantErr: true},
+		{name: "negative limit", src: `[{"url": "wss://a.example", "limit": -1}]`, wantErr: true},
+		{name: "invalid limit", src: "wss://a.example many", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			relays, limits, err := parseRelayList([]byte(tt.src))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("got %v, want an error", relays)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(relays, tt.wantRelays) {
+				t.Errorf("relays = %v, want %v", relays, tt.wantRelays)
+			}
+			if !maps.Equal(limits, tt.wantLimits) {
+				t.Errorf("limits = %v, want %v", limits, tt.wantLimits)
+			}
+		})
+	}
+}
+
+func TestValidRelays(t *testing.T) {
+	got := validRelays([]string{"wss://a.example", "https://b.example", "ws://c.example:7777", "c.example", " wss://d.example ", "wss://"})
+	want := []string{"wss://a.example", "ws://c.example:7777", "wss://d.example"}
+	if !slices.Equal(got, want) {
+		t.Errorf("validRelays = %v, want %v", got, want)
+	}
+}
+
+func TestLoadRelaysFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "relays.json")
+	if err := os.WriteFile(path, []byte(`["wss://a.example", {"url": "wss://b.example", "limit": 100}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	relays, limits, err := loadRelaysFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(relays, []string{"wss://a.example", "wss://b.example"}) || limits["wss://b.example"] != 100 {
+		t.Errorf("loadRelaysFile = %v, %v", relays, limits)
+	}
+
+	if _, _, err := loadRelaysFile(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("a missing file was loaded")
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattn/nostr-relay-ranking/ranking"
+)
+
+// generation is the outcome of one collection run.
+type generation struct {
+	renderer     *myRenderer
+	relays       []string
+	relayResults []ranking.RelayResult
+	startedAt    time.Time
+	duration     time.Duration
+	events       int    // events collected, before dedup
+	users        int    // distinct users counted
+	all          []Rank // every counted relay, for /api/ranks and /api/ranking
+}
+
+// server holds the page of the latest successful generation. Pages are
+// rendered once per refresh, not per request.
+type server struct {
+	mu        sync.RWMutex
+	page      []byte
+	feed      []byte
+	collected time.Time
+	all       []Rank
+	minCount  int
+	metrics   *metrics
+}
+
+func (s *server) update(gen *generation) error {
+	var buf bytes.Buffer
+	if err := renderHTML(&buf, gen.renderer); err != nil {
+		return err
+	}
+	var feed bytes.Buffer
+	if err := renderFeed(&feed, gen.renderer); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.page = buf.Bytes()
+	s.feed = feed.Bytes()
+	s.collected = gen.startedAt
+	s.all = gen.all
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		// Browsers get the page, API clients asking for JSON the ranks.
+		w.Header().Set("Vary", "Accept")
+		if negotiate(r.Header.Get("Accept"), "text/html", "application/json") == "application/json" {
+			s.serveRanks(w, r)
+			return
+		}
+		s.mu.RLock()
+		page, collected := s.page, s.collected
+		s.mu.RUnlock()
+		serveCached(w, r, "text/html; charset=utf-8", collected, page)
+	})
+	mux.HandleFunc("GET /feed.xml", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.RLock()
+		feed, collected := s.feed, s.collected
+		s.mu.RUnlock()
+		serveCached(w, r, "application/atom+xml", collected, feed)
+	})
+	mux.HandleFunc("GET /api/ranks", s.serveRanks)
+	mux.HandleFunc("GET /api/ranking", s.serveRanks)
+	mux.Handle("GET /metrics", s.metrics.handler())
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	return mux
+}
+
+// serveRanks answers with the ranks matching the query string as JSON.
+func (s *server) serveRanks(w http.ResponseWriter, r *http.Request) {
+	q, err := parseRankQuery(r.URL.Query(), s.minCount)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.RLock()
+	all, collected := s.all, s.collected
+	s.mu.RUnlock()
+
+	ranks := q.apply(all)
+	result := make([]jsonRank, 0, len(ranks))
+	for i, rank := range ranks {
+		jr := jsonRank{
+			Rank:        i + 1,
+			URL:         rank.Name,
+			Count:       rank.Count,
+			Score:       rank.Score,
+			Description: rank.Description,
+		}
+		if rank.Change.Name != "" {
+			delta := rank.Change.delta()
+			jr.Delta = &delta
+		}
+		result = append(result, jr)
+	}
+	b, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	serveCached(w, r, "application/json", collected, b)
+}
+
+// negotiate returns the offer the Accept header ranks highest. Each offer
+// takes the quality of the most specific media range matching it, and an
+// offer named explicitly beats one only matched by a wildcard at the same
+// quality. Remaining ties, such as a bare */*, and headers matching nothing
+// go to the first offer.
+func negotiate(accept string, offers ...string) string {
+	best, bestQ, bestLevel := offers[0], 0.0, 0
+	for _, offer := range offers {
+		typ, _, _ := strings.Cut(offer, "/")
+		q, level := 0.0, 0
+		for _, part := range strings.Split(accept, ",") {
+			mediaType, params, _ := strings.Cut(part, ";")
+			var l int
+			switch strings.ToLower(strings.TrimSpace(mediaType)) {
+			case offer:
+				l = 3
+			case typ + "/*":
+				l = 2
+			case "*/*":
+				l = 1
+			default:
+				continue
+			}
+			if l < level {
+				continue
+			}
+			weight := 1.0
+			for _, p := range strings.Split(params, ";") {
+				if v, ok := strings.CutPrefix(strings.TrimSpace(p), "q="); ok {
+					if f, err := strconv.ParseFloat(v, 64); err == nil {
+						weight = f
+					}
+				}
+			}
+			q, level = weight, l
+		}
+		if q > bestQ || q == bestQ && q > 0 && level > bestLevel {
+			best, bestQ, bestLevel = offer, q, level
+		}
+	}
+	return best
+}
+
+// serve generates the ranking, then serves it on addr and regenerates it
+// every refresh. A failed refresh keeps serving the previous ranking.
+// minCount is the default min of the API. Cancelling ctx shuts the
+// server down gracefully.
+func serve(ctx context.Context, addr string, refresh time.Duration, minCount int, generate func() (*generation, int)) int {
+	gen, code := generate()
+	if code != exitOK {
+		return code
+	}
+	s := &server{minCount: minCount, metrics: newMetrics()}
+	if err := s.update(gen); err != nil {
+		log.Print(err)
+		return exitError
+	}
+	s.metrics.record(gen)
+
+	go func() {
+		ticker := time.NewTicker(refresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			gen, code := generate()
+			if code != exitOK {
+				log.Printf("refresh failed (exit code %d), keeping the previous ranking", code)
+				s.metrics.record(nil)
+				continue
+			}
+			if err := s.update(gen); err != nil {
+				log.Printf("refresh failed: %v", err)
+				s.metrics.record(nil)
+				continue
+			}
+			s.metrics.record(gen)
+			log.Println("✨ ランキングを更新しました")
+		}
+	}()
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           s.handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("shutdown: %v", err)
+		}
+	}()
+	log.Printf("✨ %s でランキングを配信します", addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Print(err)
+		return exitError
+	}
+	log.Println("✨ 配信を終了しました")
+	return exitOK
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// printStats writes a summary of what is stored in relay_stats without
+// collecting anything.
+func printStats(w io.Writer, db *store) error {
+	var days, relays, rows int
+	var first, last sqlDate
+	err := db.QueryRow(`
+		SELECT COUNT(DISTINCT date), MIN(date), MAX(date), COUNT(DISTINCT relay_url), COUNT(*)
+		FROM relay_stats
+	`).Scan(&days, &first, &last, &relays, &rows)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "days\t%d\n", days)
+	if first.Valid && last.Valid {
+		fmt.Fprintf(tw, "range\t%s - %s\n", first.Time.Format("2006-01-02"), last.Time.Format("2006-01-02"))
+	}
+	fmt.Fprintf(tw, "relays\t%d\n", relays)
+	fmt.Fprintf(tw, "rows\t%d\n", rows)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if !last.Valid {
+		return nil
+	}
+
+	fmt.Fprintf(w, "\ntop 10 (%s)\n", last.Time.Format("2006-01-02"))
+	top, err := db.Query(`
+		SELECT relay_url, subscription_count FROM relay_stats
+		WHERE date = $1
+		ORDER BY subscription_count DESC, relay_url
+		LIMIT 10
+	`, last.Time.Format("2006-01-02"))
+	if err != nil {
+		return err
+	}
+	defer top.Close()
+	tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for i := 1; top.Next(); i++ {
+		var url string
+		var cnt int
+		if err := top.Scan(&url, &cnt); err != nil {
+			return err
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%d\n", i, url, cnt)
+	}
+	if err := top.Err(); err != nil {
+		return err
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, "\nlargest day-over-day movers")
+	movers, err := db.Query(`
+		SELECT relay_url, date, delta FROM (
+			SELECT relay_url, date,
+				subscription_count - LAG(subscription_count) OVER (PARTITION BY relay_url ORDER BY date) AS delta
+			FROM relay_stats
+		) d
+		WHERE delta IS NOT NULL AND delta <> 0
+		ORDER BY ABS(delta) DESC, date DESC, relay_url
+		LIMIT 10
+	`)
+	if err != nil {
+		return err
+	}
+	defer movers.Close()
+	tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for movers.Next() {
+		var url string
+		var date sqlDate
+		var delta int
+		if err := movers.Scan(&url, &date, &delta); err != nil {
+			return err
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%+d\n", date.Time.Format("2006-01-02"), url, delta)
+	}
+	if err := movers.Err(); err != nil {
+		return err
+	}
+	return tw.Flush()
+}
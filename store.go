@@ -0,0 +1,439 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/nostr-relay-ranking/ranking"
+)
+
+// dbDrivers are the values accepted by -db-driver.
+var dbDrivers = []string{"postgres", "sqlite"}
+
+// store is the database relay statistics are kept in. Queries are written
+// for Postgres; store rewrites their placeholders for SQLite so callers do
+// not have to care which backend they talk to.
+type store struct {
+	db     *sql.DB
+	driver string
+}
+
+// openDB connects to DATABASE_URL (a file name for SQLite) and makes sure
+// the schema is up to date.
+func openDB(driver string) (*store, error) {
+	s, err := connectDB(driver, os.Getenv("DATABASE_URL"), false)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.initSchema(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// openDBReadOnly connects to DATABASE_URL like openDB but neither migrates
+// the schema nor backfills it, for runs that must leave the database as it
+// is. SQLite databases are opened read-only.
+func openDBReadOnly(driver string) (*store, error) {
+	return connectDB(driver, os.Getenv("DATABASE_URL"), true)
+}
+
+func connectDB(driver, dsn string, readOnly bool) (*store, error) {
+	name := driver
+	if driver == "sqlite" {
+		name = "sqlite3"
+		if readOnly {
+			dsn = sqliteReadOnly(dsn)
+		}
+	}
+	db, err := sql.Open(name, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if driver == "sqlite" {
+		// SQLite allows a single writer; relay info is saved concurrently.
+		db.SetMaxOpenConns(1)
+	}
+	return &store{db: db, driver: driver}, nil
+}
+
+// sqliteReadOnly turns a SQLite file name or file: URI into a URI opening
+// the database read-only.
+func sqliteReadOnly(dsn string) string {
+	if !strings.HasPrefix(dsn, "file:") {
+		dsn = "file:" + dsn
+	}
+	if strings.Contains(dsn, "?") {
+		return dsn + "&mode=ro"
+	}
+	return dsn + "?mode=ro"
+}
+
+func (s *store) Close() error {
+	return s.db.Close()
+}
+
+// rebind turns the $1, $2, ... placeholders of query into ? for SQLite.
+func (s *store) rebind(query string) string {
+	if s.driver != "sqlite" {
+		return query
+	}
+	var b strings.Builder
+	for i := 0; i < len(query); i++ {
+		if query[i] == '$' && i+1 < len(query) && query[i+1] >= '0' && query[i+1] <= '9' {
+			b.WriteByte('?')
+			for i+1 < len(query) && query[i+1] >= '0' && query[i+1] <= '9' {
+				i++
+			}
+			continue
+		}
+		b.WriteByte(query[i])
+	}
+	return b.String()
+}
+
+func (s *store) Exec(query string, args ...any) (sql.Result, error) {
+	return s.db.Exec(s.rebind(query), args...)
+}
+
+func (s *store) Query(query string, args ...any) (*sql.Rows, error) {
+	return s.db.Query(s.rebind(query), args...)
+}
+
+func (s *store) QueryRow(query string, args ...any) *sql.Row {
+	return s.db.QueryRow(s.rebind(query), args...)
+}
+
+// storeTx is a transaction of a store, rebinding queries like store does.
+type storeTx struct {
+	tx *sql.Tx
+	s  *store
+}
+
+func (s *store) Begin() (*storeTx, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &storeTx{tx: tx, s: s}, nil
+}
+
+func (t *storeTx) Exec(query string, args ...any) (sql.Result, error) {
+	return t.tx.Exec(t.s.rebind(query), args...)
+}
+
+func (t *storeTx) Prepare(query string) (*sql.Stmt, error) {
+	return t.tx.Prepare(t.s.rebind(query))
+}
+
+func (t *storeTx) Commit() error   { return t.tx.Commit() }
+func (t *storeTx) Rollback() error { return t.tx.Rollback() }
+
+// addColumn adds column to table unless it already exists. SQLite has no
+// ADD COLUMN IF NOT EXISTS, so the table is inspected instead.
+func (s *store) addColumn(table, column, typ string) error {
+	if s.driver != "sqlite" {
+		_, err := s.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s", table, column, typ))
+		return err
+	}
+	var n int
+	err := s.QueryRow("SELECT COUNT(*) FROM pragma_table_info($1) WHERE name = $2", table, column).Scan(&n)
+	if err != nil || n > 0 {
+		return err
+	}
+	_, err = s.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, typ))
+	return err
+}
+
+func (s *store) initSchema() error {
+	id := "id SERIAL PRIMARY KEY"
+	if s.driver == "sqlite" {
+		id = "id INTEGER PRIMARY KEY AUTOINCREMENT"
+	}
+	_, err := s.Exec(`
+		CREATE TABLE IF NOT EXISTS relay_stats (
+			` + id + `,
+			date DATE NOT NULL,
+			relay_url TEXT NOT NULL,
+			subscription_count INTEGER NOT NULL,
+			UNIQUE(date, relay_url)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.Exec(`
+		CREATE INDEX IF NOT EXISTS idx_relay_stats_url_date
+		ON relay_stats(relay_url, date)
+	`)
+	if err != nil {
+		return err
+	}
+
+	if err := s.addColumn("relay_stats", "weighted_count", "DOUBLE PRECISION"); err != nil {
+		return err
+	}
+	if err := s.addColumn("relay_stats", "decayed_count", "DOUBLE PRECISION"); err != nil {
+		return err
+	}
+	if err := s.addColumn("relay_stats", "bridged_count", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := s.addColumn("relay_stats", "read_count", "INTEGER"); err != nil {
+		return err
+	}
+	if err := s.addColumn("relay_stats", "write_count", "INTEGER"); err != nil {
+		return err
+	}
+	// Rows saved before markers were counted have no split: treat all their
+	// users as unmarked, which -mode read and write both count.
+	if _, err := s.Exec("UPDATE relay_stats SET read_count = subscription_count WHERE read_count IS NULL"); err != nil {
+		return err
+	}
+	if _, err := s.Exec("UPDATE relay_stats SET write_count = subscription_count WHERE write_count IS NULL"); err != nil {
+		return err
+	}
+	if err := s.addColumn("relay_stats", "country", "TEXT"); err != nil {
+		return err
+	}
+	if err := s.addColumn("relay_stats", "latency_ms", "INTEGER"); err != nil {
+		return err
+	}
+
+	_, err = s.Exec(`
+		CREATE TABLE IF NOT EXISTS relays_per_user (
+			date DATE NOT NULL,
+			bucket TEXT NOT NULL,
+			users INTEGER NOT NULL,
+			UNIQUE(date, bucket)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.Exec(`
+		CREATE TABLE IF NOT EXISTS relay_info (
+			relay_url TEXT PRIMARY KEY,
+			info TEXT NOT NULL,
+			fetched_at TIMESTAMP NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.Exec(`
+		CREATE TABLE IF NOT EXISTS run_meta (
+			date DATE NOT NULL UNIQUE,
+			users INTEGER NOT NULL,
+			relays INTEGER NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	if err := s.addColumn("run_meta", "list_min", "INTEGER"); err != nil {
+		return err
+	}
+	if err := s.addColumn("run_meta", "list_median", "DOUBLE PRECISION"); err != nil {
+		return err
+	}
+	if err := s.addColumn("run_meta", "list_max", "INTEGER"); err != nil {
+		return err
+	}
+	return s.addColumn("run_meta", "list_mean", "DOUBLE PRECISION")
+}
+
+// relayStat is one relay_stats row.
+type relayStat struct {
+	URL         string
+	Count       int
+	Weighted    float64
+	Decayed     sql.NullFloat64 // set when -decay is used
+	Bridged     int
+	Read, Write int
+}
+
+// runMeta describes the sample a day was computed from.
+type runMeta struct {
+	Users  int // distinct pubkeys with a relay list
+	Relays int // relays queried, 0 when read from -events-file
+	Sizes  ranking.ListSizeStats
+}
+
+// saveDay replaces the rows of day in one transaction, so a failure in the
+// middle leaves the previous data of that day in place rather than a
+// partial time series.
+func (s *store) saveDay(day string, stats []relayStat, histogram []int, meta runMeta) (err error) {
+	tx, err := s.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if _, err := tx.Exec("DELETE FROM relay_stats WHERE date = $1", day); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare("INSERT INTO relay_stats(date, relay_url, subscription_count, weighted_count, decayed_count, bridged_count, read_count, write_count) VALUES($1, $2, $3, $4, $5, $6, $7, $8)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, st := range stats {
+		if _, err := stmt.Exec(day, st.URL, st.Count, st.Weighted, st.Decayed, st.Bridged, st.Read, st.Write); err != nil {
+			return fmt.Errorf("insert %s: %w", st.URL, err)
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM relays_per_user WHERE date = $1", day); err != nil {
+		return err
+	}
+	for i, b := range ranking.UserRelayBuckets {
+		if _, err := tx.Exec("INSERT INTO relays_per_user(date, bucket, users) VALUES($1, $2, $3)", day, b.Label, histogram[i]); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM run_meta WHERE date = $1", day); err != nil {
+		return err
+	}
+	sz := meta.Sizes
+	if _, err := tx.Exec("INSERT INTO run_meta(date, users, relays, list_min, list_median, list_max, list_mean) VALUES($1, $2, $3, $4, $5, $6, $7)",
+		day, meta.Users, meta.Relays, sz.Min, sz.Median, sz.Max, sz.Mean); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// listSizes returns the stored ListSizeStats of the days since the given
+// date, by date. Days saved before they were recorded are left out.
+func (s *store) listSizes(since string) (map[string]ranking.ListSizeStats, error) {
+	rows, err := s.Query("SELECT date, list_min, list_median, list_max, list_mean FROM run_meta WHERE date >= $1 AND list_mean IS NOT NULL", since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sizes := make(map[string]ranking.ListSizeStats)
+	for rows.Next() {
+		var date sqlDate
+		var sz ranking.ListSizeStats
+		if err := rows.Scan(&date, &sz.Min, &sz.Median, &sz.Max, &sz.Mean); err != nil {
+			return nil, err
+		}
+		sizes[date.Time.Format("2006-01-02")] = sz
+	}
+	return sizes, rows.Err()
+}
+
+// relayAttrs are the per relay facts found after ranking: the country it was
+// attributed to and its connect latency, both possibly unknown.
+type relayAttrs struct {
+	Country string
+	Latency time.Duration
+}
+
+// setRelayAttrs records the attributes of the relays of day. Unknown values
+// are stored as NULL.
+func (s *store) setRelayAttrs(day string, attrs map[string]relayAttrs) (err error) {
+	tx, err := s.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	for url, a := range attrs {
+		var country sql.NullString
+		var latency sql.NullInt64
+		if a.Country != "" {
+			country = sql.NullString{String: a.Country, Valid: true}
+		}
+		if a.Latency > 0 {
+			latency = sql.NullInt64{Int64: a.Latency.Milliseconds(), Valid: true}
+		}
+		if _, err := tx.Exec("UPDATE relay_stats SET country = $1, latency_ms = $2 WHERE date = $3 AND relay_url = $4", country, latency, day, url); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// saveRelayInfo keeps the NIP-11 document of a relay, so it can still be
+// shown when a later fetch fails.
+func (s *store) saveRelayInfo(url string, info ranking.RelayInfo, fetchedAt time.Time) error {
+	b, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	_, err = s.Exec(`
+		INSERT INTO relay_info(relay_url, info, fetched_at) VALUES($1, $2, $3)
+		ON CONFLICT(relay_url) DO UPDATE SET info = excluded.info, fetched_at = excluded.fetched_at
+	`, url, string(b), fetchedAt.UTC())
+	return err
+}
+
+// storedRelayInfo returns the last NIP-11 document saved for url and when it
+// was fetched, or sql.ErrNoRows.
+func (s *store) storedRelayInfo(url string) (ranking.RelayInfo, time.Time, error) {
+	var info ranking.RelayInfo
+	var b string
+	var fetchedAt time.Time
+	err := s.QueryRow("SELECT info, fetched_at FROM relay_info WHERE relay_url = $1", url).Scan(&b, &fetchedAt)
+	if err != nil {
+		return info, fetchedAt, err
+	}
+	if err := json.Unmarshal([]byte(b), &info); err != nil {
+		return info, fetchedAt, fmt.Errorf("relay_info of %s: %w", url, err)
+	}
+	return info, fetchedAt, nil
+}
+
+// prune deletes the relay_stats rows dated before cutoff (YYYY-MM-DD) and
+// returns how many were removed.
+func (s *store) prune(cutoff string) (int64, error) {
+	res, err := s.Exec("DELETE FROM relay_stats WHERE date < $1", cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// sqlDate scans a DATE column. Postgres returns a time.Time, SQLite returns
+// the stored text for computed columns such as MIN(date).
+type sqlDate struct {
+	sql.NullTime
+}
+
+func (d *sqlDate) Scan(v any) error {
+	var s string
+	switch v := v.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		return d.NullTime.Scan(v)
+	}
+	t, err := time.Parse("2006-01-02", s[:min(len(s), 10)])
+	if err != nil {
+		return fmt.Errorf("invalid date %s", strconv.Quote(s))
+	}
+	d.Time, d.Valid = t, true
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"database/sql"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/mattn/nostr-relay-ranking/ranking"
+)
+
+// openTestDB returns a migrated in-memory SQLite store.
+func openTestDB(t *testing.T) *store {
+	t.Helper()
+	db, err := connectDB("sqlite", ":memory:", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.initSchema(); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestStoreSQLite(t *testing.T) {
+	db := openTestDB(t)
+	// Migrating an up to date schema again must work too.
+	if err := db.initSchema(); err != nil {
+		t.Fatalf("second initSchema: %v", err)
+	}
+
+	histogram := make([]int, len(ranking.UserRelayBuckets))
+	meta := runMeta{Users: 120, Relays: 5, Sizes: ranking.ListSizeStats{Min: 1, Median: 3, Max: 9, Mean: 3.5}}
+	stats := []relayStat{
+		{URL: "wss://a.example", Count: 40, Weighted: 12.5, Read: 30, Write: 35},
+		{URL: "wss://b.example", Count: 25, Weighted: 8, Decayed: sql.NullFloat64{Float64: 6.25, Valid: true}},
+	}
+	if err := db.saveDay("2026-10-01", stats, histogram, meta); err != nil {
+		t.Fatal(err)
+	}
+	// Saving a day again replaces its rows.
+	stats[0].Count = 41
+	if err := db.saveDay("2026-10-01", stats, histogram, meta); err != nil {
+		t.Fatal(err)
+	}
+
+	var rows, count int
+	var weighted float64
+	if err := db.QueryRow("SELECT COUNT(*) FROM relay_stats WHERE date = $1", "2026-10-01").Scan(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if rows != 2 {
+		t.Errorf("got %d rows for the day, want 2", rows)
+	}
+	err := db.QueryRow("SELECT subscription_count, weighted_count FROM relay_stats WHERE date = $1 AND relay_url = $2", "2026-10-01", "wss://a.example").Scan(&count, &weighted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 41 || weighted != 12.5 {
+		t.Errorf("a.example: count %d weighted %v, want 41 and 12.5", count, weighted)
+	}
+
+	sizes, err := db.listSizes("2026-09-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sizes["2026-10-01"] != meta.Sizes {
+		t.Errorf("listSizes = %v, want %v on 2026-10-01", sizes, meta.Sizes)
+	}
+
+	info := ranking.RelayInfo{Description: "a relay", Software: "strfry"}
+	if err := db.saveRelayInfo("wss://a.example", info, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	stored, _, err := db.storedRelayInfo("wss://a.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Description != info.Description || stored.Software != info.Software {
+		t.Errorf("storedRelayInfo = %+v, want %+v", stored, info)
+	}
+}
+
+func TestStorePrune(t *testing.T) {
+	db := openTestDB(t)
+	histogram := make([]int, len(ranking.UserRelayBuckets))
+	for _, day := range []string{"2026-06-30", "2026-07-01", "2026-07-02", "2026-10-01"} {
+		stats := []relayStat{{URL: "wss://a.example", Count: 30}, {URL: "wss://b.example", Count: 20}}
+		if err := db.saveDay(day, stats, histogram, runMeta{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := db.prune("2026-07-02")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Errorf("pruned %d rows, want the 4 of the two days before the cutoff", n)
+	}
+	rows, err := db.Query("SELECT DISTINCT date FROM relay_stats ORDER BY date")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var days []string
+	for rows.Next() {
+		var d sqlDate
+		if err := rows.Scan(&d); err != nil {
+			t.Fatal(err)
+		}
+		days = append(days, d.Time.Format("2006-01-02"))
+	}
+	if !slices.Equal(days, []string{"2026-07-02", "2026-10-01"}) {
+		t.Errorf("days left %v, want 2026-07-02 and 2026-10-01", days)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+type typoPair struct {
+	A, B           string
+	CountA, CountB int
+	Distance       int
+}
+
+// levenshtein returns the edit distance between a and b.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func relayHost(rurl string) string {
+	u, err := url.Parse(rurl)
+	if err != nil || u.Host == "" {
+		return rurl
+	}
+	return strings.ToLower(u.Host)
+}
+
+// findTypoPairs reports pairs of counted relays whose hosts are within
+// maxDistance edits of each other. Nothing is merged, it is only a hint.
+func findTypoPairs(result map[string]int, maxDistance int) []typoPair {
+	urls := make([]string, 0, len(result))
+	for u := range result {
+		urls = append(urls, u)
+	}
+	sort.Strings(urls)
+
+	hosts := make([]string, len(urls))
+	for i, u := range urls {
+		hosts[i] = relayHost(u)
+	}
+
+	var pairs []typoPair
+	for i := 0; i < len(urls); i++ {
+		for j := i + 1; j < len(urls); j++ {
+			diff := len(hosts[i]) - len(hosts[j])
+			if diff > maxDistance || -diff > maxDistance {
+				continue
+			}
+			d := levenshtein(hosts[i], hosts[j])
+			if d > maxDistance {
+				continue
+			}
+			pairs = append(pairs, typoPair{
+				A: urls[i], B: urls[j],
+				CountA: result[urls[i]], CountB: result[urls[j]],
+				Distance: d,
+			})
+		}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool {
+		if pairs[i].Distance != pairs[j].Distance {
+			return pairs[i].Distance < pairs[j].Distance
+		}
+		return pairs[i].CountA+pairs[i].CountB > pairs[j].CountA+pairs[j].CountB
+	})
+	return pairs
+}
+
+func writeTypoReport(w io.Writer, pairs []typoPair) error {
+	for _, p := range pairs {
+		if _, err := fmt.Fprintf(w, "%d\t%s (%d)\t%s (%d)\n", p.Distance, p.A, p.CountA, p.B, p.CountB); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func saveTypoReport(path string, result map[string]int, maxDistance int) error {
+	pairs := findTypoPairs(result, maxDistance)
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeTypoReport(f, pairs); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	log.Printf("✨ 類似したリレーURLの組 %d 件を %s に出力しました", len(pairs), path)
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/mattn/nostr-relay-ranking/ranking"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// withoutWWW returns rurl with a leading "www." removed from its host.
+func withoutWWW(rurl string) string {
+	u, err := url.Parse(rurl)
+	if err != nil || !strings.HasPrefix(strings.ToLower(u.Host), "www.") {
+		return rurl
+	}
+	u.Host = u.Host[len("www."):]
+	return u.String()
+}
+
+// withoutPath returns rurl with its path removed, so wss://host/v1 becomes
+// wss://host.
+func withoutPath(rurl string) string {
+	u, err := url.Parse(rurl)
+	if err != nil || u.Path == "" {
+		return rurl
+	}
+	u.Path, u.RawPath = "", ""
+	return u.String()
+}
+
+// mergeVariants rewrites the r tags of events so that relay URLs mapped to
+// the same key, such as www and bare host variants with withoutWWW, are
+// counted as one. The variant listed by more users is kept as the displayed
+// URL; on a tie the key itself, or else the lowest URL, so that runs agree.
+// Merges are logged after the name of the flag that asked for them.
+func mergeVariants(events []*nostr.Event, keyOf func(string) string, flagName string) {
+	variants := make(map[string]map[string]int)
+	for _, ev := range events {
+		for _, rurl := range ranking.EventRelays(ev) {
+			key := keyOf(rurl)
+			if variants[key] == nil {
+				variants[key] = make(map[string]int)
+			}
+			variants[key][rurl]++
+		}
+	}
+
+	canonical := make(map[string]string)
+	for key, counts := range variants {
+		if len(counts) < 2 {
+			continue
+		}
+		best := ""
+		for rurl, n := range counts {
+			switch {
+			case best == "", n > counts[best]:
+				best = rurl
+			case n == counts[best] && best != key && (rurl == key || rurl < best):
+				best = rurl
+			}
+		}
+		for rurl := range counts {
+			if rurl != best {
+				canonical[rurl] = best
+				log.Printf("%s: merging %s into %s", flagName, rurl, best)
+			}
+		}
+	}
+	if len(canonical) == 0 {
+		return
+	}
+
+	for _, ev := range events {
+		for _, tag := range ev.Tags {
+			if len(tag) >= 2 && tag[0] == "r" {
+				if to, ok := canonical[ranking.NormalizeRelayURL(tag[1])]; ok {
+					tag[1] = to
+				}
+			}
+		}
+	}
+}
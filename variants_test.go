@@ -0,0 +1,169 @@
+package main
+
+import (
+	"maps"
+	"testing"
+
+	"github.com/mattn/nostr-relay-ranking/ranking"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// userList returns a kind 10002 event of pubkey with an r tag per relay.
+func userList(pubkey string, relays ...string) *nostr.Event {
+	ev := &nostr.Event{ID: pubkey, PubKey: pubkey, Kind: 10002}
+	for _, r := range relays {
+		ev.Tags = append(ev.Tags, nostr.Tag{"r", r})
+	}
+	return ev
+}
+
+// checkMerge merges copies of events by keyOf and compares the tally with
+// want, repeatedly, since the choice must not depend on map order.
+func checkMerge(t *testing.T, events []*nostr.Event, keyOf func(string) string, want map[string]int) {
+	t.Helper()
+	for range 20 {
+		merged := make([]*nostr.Event, len(events))
+		for i, ev := range events {
+			clone := *ev
+			clone.Tags = make(nostr.Tags, len(ev.Tags))
+			for j, tag := range ev.Tags {
+				clone.Tags[j] = append(nostr.Tag(nil), tag...)
+			}
+			merged[i] = &clone
+		}
+		mergeVariants(merged, keyOf, "test")
+		if got := ranking.TallyRelays(merged); !maps.Equal(got, want) {
+			t.Fatalf("TallyRelays after merging = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestWithoutWWW(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"wss://www.relay.example", "wss://relay.example"},
+		{"wss://WWW.relay.example:7777/path", "wss://relay.example:7777/path"},
+		{"wss://relay.example", "wss://relay.example"},
+		{"wss://wwwrelay.example", "wss://wwwrelay.example"},
+		{"wss://relay.www.example", "wss://relay.www.example"},
+		{"%zz", "%zz"},
+	}
+	for _, tt := range tests {
+		if got := withoutWWW(tt.in); got != tt.want {
+			t.Errorf("withoutWWW(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMergeVariantsStripWWW(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []*nostr.Event
+		want   map[string]int
+	}{
+		{
+			name: "the www variant is more common",
+			events: []*nostr.Event{
+				userList("alice", "wss://www.relay.example"),
+				userList("bob", "wss://www.relay.example"),
+				userList("carol", "wss://relay.example"),
+			},
+			want: map[string]int{"wss://www.relay.example": 3},
+		},
+		{
+			name: "the bare host is more common",
+			events: []*nostr.Event{
+				userList("alice", "wss://www.relay.example"),
+				userList("bob", "wss://relay.example"),
+				userList("carol", "wss://Relay.Example/"),
+			},
+			want: map[string]int{"wss://relay.example": 3},
+		},
+		{
+			name: "the bare host wins a tie",
+			events: []*nostr.Event{
+				userList("alice", "wss://www.relay.example"),
+				userList("bob", "wss://relay.example"),
+				// Listing both variants still counts once.
+				userList("carol", "wss://www.relay.example", "wss://relay.example"),
+			},
+			want: map[string]int{"wss://relay.example": 3},
+		},
+		{
+			name: "other hosts stay apart",
+			events: []*nostr.Event{
+				userList("alice", "wss://www.relay.example", "wss://www.other.example"),
+				userList("bob", "wss://relay.example:7777"),
+			},
+			want: map[string]int{"wss://www.relay.example": 1, "wss://www.other.example": 1, "wss://relay.example:7777": 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkMerge(t, tt.events, withoutWWW, tt.want)
+		})
+	}
+}
+
+func TestWithoutPath(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"wss://relay.example/v1", "wss://relay.example"},
+		{"wss://relay.example:7777/a/b", "wss://relay.example:7777"},
+		{"wss://relay.example", "wss://relay.example"},
+		{"wss://[2001:db8::1]/v1", "wss://[2001:db8::1]"},
+		{"%zz", "%zz"},
+	}
+	for _, tt := range tests {
+		if got := withoutPath(tt.in); got != tt.want {
+			t.Errorf("withoutPath(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMergeVariantsIgnorePath(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []*nostr.Event
+		want   map[string]int
+	}{
+		{
+			name: "the most listed variant wins",
+			events: []*nostr.Event{
+				userList("alice", "wss://relay.example/v1"),
+				userList("bob", "wss://relay.example/v1"),
+				userList("carol", "wss://relay.example"),
+			},
+			want: map[string]int{"wss://relay.example/v1": 3},
+		},
+		{
+			name: "the bare host wins a tie",
+			events: []*nostr.Event{
+				userList("alice", "wss://relay.example/v1"),
+				userList("bob", "wss://relay.example/"),
+			},
+			want: map[string]int{"wss://relay.example": 2},
+		},
+		{
+			name: "the lowest path wins a tie without the bare host",
+			events: []*nostr.Event{
+				userList("alice", "wss://relay.example/b"),
+				userList("bob", "wss://relay.example/a"),
+				// Listing both variants still counts once.
+				userList("carol", "wss://relay.example/a", "wss://relay.example/b"),
+			},
+			want: map[string]int{"wss://relay.example/a": 3},
+		},
+		{
+			name: "other hosts and ports stay apart",
+			events: []*nostr.Event{
+				userList("alice", "wss://relay.example/v1", "wss://relay.example:7777/v1"),
+				userList("bob", "wss://other.example/v1"),
+			},
+			want: map[string]int{"wss://relay.example/v1": 1, "wss://relay.example:7777/v1": 1, "wss://other.example/v1": 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkMerge(t, tt.events, withoutPath, tt.want)
+		})
+	}
+}